@@ -0,0 +1,337 @@
+// Package autodiff does reverse-mode automatic differentiation on top of
+// mottuMat. Every operation on a Var is recorded on its Tape along with how
+// to push gradients back to its inputs, so a model written with these
+// operations gets the gradients of all its inputs from one Backward call.
+//
+//    t := autodiff.MakeTape()
+//    w, b, x := t.Var(weights), t.Var(biases), t.Var(input)
+//    a := w.Mul(x).AddCol(b).Sigmoid()
+//    cost := a.Sub(t.Var(expected)).Square().Sum().Scale(0.5)
+//    cost.Backward()
+//    // w.Grad() and b.Grad() now hold partial cost/partial w and b
+package autodiff
+
+import (
+    "math"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Tape records the operations done on its Vars in the order they happen
+type Tape struct {
+    vars []*Var
+    pass int // counts the Resets
+}
+
+// Var is a matrix whose gradient the tape can find
+type Var struct {
+    tape *Tape
+    pass int // the pass of the tape v was recorded in
+    index int // position on the tape
+    value *mottuMat.MottuMat
+    grad *mottuMat.MottuMat
+    // backward adds the contribution of grad to the grads of the inputs.
+    // It is nil for the Vars made by Tape.Var.
+    backward func()
+}
+
+// MakeTape returns an empty tape
+func MakeTape() *Tape {
+    return new(Tape)
+}
+
+// Var puts a matrix on the tape, e.g. a parameter or an input. Its value is
+// used as is, so changes to m show up in the operations recorded after.
+func (t *Tape) Var(m *mottuMat.MottuMat) *Var {
+    return t.record(m, nil)
+}
+
+// ZeroGrads clears the gradients of every Var on the tape
+func (t *Tape) ZeroGrads() {
+    for _, v := range t.vars {
+        v.grad = nil
+    }
+}
+
+// Reset forgets every recorded operation, so the tape can be reused for a
+// new pass. The Vars recorded so far, including the ones made by Tape.Var,
+// lose their gradients and can't be used any more: parameters have to be
+// put on the tape again with Tape.Var after every Reset.
+func (t *Tape) Reset() {
+    for _, v := range t.vars {
+        v.grad = nil
+    }
+    t.vars = t.vars[:0]
+    t.pass++
+}
+
+func (t *Tape) record(value *mottuMat.MottuMat, backward func()) *Var {
+    v := &Var{tape: t, pass: t.pass, index: len(t.vars), value: value, backward: backward}
+    t.vars = append(t.vars, v)
+    return v
+}
+
+// Value returns the value of the Var
+func (v *Var) Value() *mottuMat.MottuMat {
+    return v.value
+}
+
+// Grad returns partial out/partial v from the last Backward, or a zero
+// matrix when v doesn't affect out
+func (v *Var) Grad() *mottuMat.MottuMat {
+    if v.grad == nil {
+        return mottuMat.MakeMat(v.value.Rows(), v.value.Cols())
+    }
+    return v.grad
+}
+
+// Backward finds the gradient of v with respect to every Var recorded before
+// it. When v isn't 1x1 this is the gradient of the sum of its entries.
+// The gradients of the Vars made by Tape.Var add up over calls until the
+// tape's ZeroGrads.
+func (v *Var) Backward() {
+    v.check()
+    // Results of operations only hold this pass's gradient
+    for _, u := range v.tape.vars[:v.index+1] {
+        if u.backward != nil {
+            u.grad = nil
+        }
+    }
+    seed := mottuMat.MakeMat(v.value.Rows(), v.value.Cols())
+    seed.ApplyFuncEq(func(float64) float64 { return 1 })
+    v.add_grad(seed)
+    for i := v.index; i >= 0; i-- {
+        if u := v.tape.vars[i]; u.grad != nil && u.backward != nil {
+            u.backward()
+        }
+    }
+}
+
+func (v *Var) add_grad(g *mottuMat.MottuMat) {
+    if v.grad == nil {
+        v.grad = g.Copy()
+        return
+    }
+    v.grad.AddEq(g)
+}
+
+func (v *Var) same_tape(b *Var) {
+    if v.tape != b.tape {
+        panic("Vars from different tapes can't be mixed")
+    }
+    b.check()
+}
+
+// check panics if the tape was reset since v was recorded
+func (v *Var) check() {
+    if v.pass != v.tape.pass {
+        panic("Var used after its tape was reset, put it on the tape again with Tape.Var")
+    }
+}
+
+// record puts the result of an operation on v on the tape
+func (v *Var) record(value *mottuMat.MottuMat, backward func()) *Var {
+    v.check()
+    return v.tape.record(value, backward)
+}
+
+// ==================== operations ===============
+
+// Add returns v + b
+func (v *Var) Add(b *Var) *Var {
+    v.same_tape(b)
+    var out *Var
+    out = v.record(v.value.Add(b.value), func() {
+        v.add_grad(out.grad)
+        b.add_grad(out.grad)
+    })
+    return out
+}
+
+// Sub returns v - b
+func (v *Var) Sub(b *Var) *Var {
+    v.same_tape(b)
+    var out *Var
+    out = v.record(v.value.Sub(b.value), func() {
+        v.add_grad(out.grad)
+        b.add_grad(out.grad.Scale(-1))
+    })
+    return out
+}
+
+// Mul returns the matrix product v.b
+func (v *Var) Mul(b *Var) *Var {
+    v.same_tape(b)
+    var out *Var
+    out = v.record(v.value.Mul(b.value), func() {
+        v.add_grad(out.grad.MulT(b.value))
+        b.add_grad(v.value.TMul(out.grad))
+    })
+    return out
+}
+
+// HadMul returns the element-wise product of v and b
+func (v *Var) HadMul(b *Var) *Var {
+    v.same_tape(b)
+    var out *Var
+    out = v.record(v.value.HadMul(b.value), func() {
+        v.add_grad(out.grad.HadMul(b.value))
+        b.add_grad(out.grad.HadMul(v.value))
+    })
+    return out
+}
+
+// Scale returns c*v
+func (v *Var) Scale(c float64) *Var {
+    var out *Var
+    out = v.record(v.value.Scale(c), func() {
+        v.add_grad(out.grad.Scale(c))
+    })
+    return out
+}
+
+// Transpose returns v^T
+func (v *Var) Transpose() *Var {
+    var out *Var
+    out = v.record(v.value.Transpose(), func() {
+        v.add_grad(out.grad.Transpose())
+    })
+    return out
+}
+
+// ApplyFunc applies f to every entry of v. fp is the derivative of f.
+func (v *Var) ApplyFunc(f, fp func(float64) float64) *Var {
+    var out *Var
+    out = v.record(v.value.ApplyFunc(f), func() {
+        v.add_grad(v.value.ApplyFunc(fp).HadMul(out.grad))
+    })
+    return out
+}
+
+// AddCol adds the col vec b to every column of v, e.g. the biases of a
+// layer to a batch of weighted inputs
+func (v *Var) AddCol(b *Var) *Var {
+    v.same_tape(b)
+    result := v.value.Copy()
+    result.AddColEq(b.value)
+    var out *Var
+    out = v.record(result, func() {
+        v.add_grad(out.grad)
+        b.add_grad(out.grad.SumCols())
+    })
+    return out
+}
+
+// SumCols adds up the columns of v into a col vec
+func (v *Var) SumCols() *Var {
+    var out *Var
+    out = v.record(v.value.SumCols(), func() {
+        g := mottuMat.MakeMat(v.value.Rows(), v.value.Cols())
+        for i := 0; i < g.Rows(); i++ {
+            for j := 0; j < g.Cols(); j++ {
+                g.SetElem(i, j, out.grad.GetElem(i, 0))
+            }
+        }
+        v.add_grad(g)
+    })
+    return out
+}
+
+// Sum adds up every entry of v into a 1x1 matrix
+func (v *Var) Sum() *Var {
+    sum := mottuMat.MakeMat(1, 1)
+    for i := 0; i < v.value.Rows(); i++ {
+        for j := 0; j < v.value.Cols(); j++ {
+            sum.SetElem(0, 0, sum.GetElem(0, 0) + v.value.GetElem(i, j))
+        }
+    }
+    var out *Var
+    out = v.record(sum, func() {
+        d := out.grad.GetElem(0, 0)
+        v.add_grad(v.value.ApplyFunc(func(float64) float64 { return d }))
+    })
+    return out
+}
+
+// Square squares every entry of v
+func (v *Var) Square() *Var {
+    return v.ApplyFunc(func(x float64) float64 { return x*x },
+                       func(x float64) float64 { return 2*x })
+}
+
+// Log takes the natural log of every entry of v
+func (v *Var) Log() *Var {
+    return v.ApplyFunc(math.Log, func(x float64) float64 { return 1/x })
+}
+
+// Exp takes e to the power of every entry of v
+func (v *Var) Exp() *Var {
+    return v.ApplyFunc(math.Exp, math.Exp)
+}
+
+// Sigmoid applies 1/(1+e^-x) to every entry of v
+func (v *Var) Sigmoid() *Var {
+    return v.ApplyFunc(sigmoid, func(x float64) float64 {
+        s := sigmoid(x)
+        return s*(1-s)
+    })
+}
+
+// Tanh applies tanh to every entry of v
+func (v *Var) Tanh() *Var {
+    return v.ApplyFunc(math.Tanh, func(x float64) float64 {
+        t := math.Tanh(x)
+        return 1-t*t
+    })
+}
+
+// ReLU applies max(0, x) to every entry of v
+func (v *Var) ReLU() *Var {
+    return v.ApplyFunc(func(x float64) float64 { return math.Max(0, x) },
+                       func(x float64) float64 {
+                           if x > 0 {
+                               return 1
+                           }
+                           return 0
+                       })
+}
+
+// Softmax turns every column of v into a probability distribution
+func (v *Var) Softmax() *Var {
+    a := mottuMat.MakeMat(v.value.Rows(), v.value.Cols())
+    for j := 0; j < a.Cols(); j++ {
+        // Shift by the max so exp can't overflow
+        max_x := math.Inf(-1)
+        for i := 0; i < a.Rows(); i++ {
+            max_x = math.Max(max_x, v.value.GetElem(i, j))
+        }
+        sum := 0.0
+        for i := 0; i < a.Rows(); i++ {
+            e := math.Exp(v.value.GetElem(i, j)-max_x)
+            a.SetElem(i, j, e)
+            sum += e
+        }
+        for i := 0; i < a.Rows(); i++ {
+            a.SetElem(i, j, a.GetElem(i, j)/sum)
+        }
+    }
+    var out *Var
+    out = v.record(a, func() {
+        // J^T g = a (.) (g - a.g), column by column
+        g := mottuMat.MakeMat(a.Rows(), a.Cols())
+        for j := 0; j < a.Cols(); j++ {
+            dot := 0.0
+            for i := 0; i < a.Rows(); i++ {
+                dot += a.GetElem(i, j)*out.grad.GetElem(i, j)
+            }
+            for i := 0; i < a.Rows(); i++ {
+                g.SetElem(i, j, a.GetElem(i, j)*(out.grad.GetElem(i, j)-dot))
+            }
+        }
+        v.add_grad(g)
+    })
+    return out
+}
+
+func sigmoid(x float64) float64 {
+    return 1/(1+math.Exp(-x))
+}
@@ -0,0 +1,171 @@
+package autodiff
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+func random_mat(rows, cols int, r *rand.Rand, lo, hi float64) *mottuMat.MottuMat {
+    m := mottuMat.MakeMat(rows, cols)
+    m.RandomizeUniform(r, lo, hi)
+    return m
+}
+
+// away_from_zero returns entries at least 0.1 from 0, so ReLU's kink is out
+// of reach of the finite differences
+func away_from_zero(rows, cols int, r *rand.Rand) *mottuMat.MottuMat {
+    return random_mat(rows, cols, r, 0.1, 1).HadMul(random_mat(rows, cols, r, -1, 1).ApplyFunc(func(x float64) float64 {
+        if x < 0 {
+            return -1
+        }
+        return 1
+    }))
+}
+
+// weighted_loss is the sum of the entries of f(inputs) weighted by w, so
+// every entry of the result gets its own gradient
+func weighted_loss(f func(in []*Var) *Var, inputs []*mottuMat.MottuMat, w *mottuMat.MottuMat) (*Var, []*Var) {
+    tape := MakeTape()
+    vars := make([]*Var, len(inputs))
+    for i, m := range inputs {
+        vars[i] = tape.Var(m)
+    }
+    return f(vars).HadMul(tape.Var(w)).Sum(), vars
+}
+
+// check_grad compares the gradients Backward finds for the inputs of f with
+// central differences
+func check_grad(t *testing.T, name string, f func(in []*Var) *Var, inputs ...*mottuMat.MottuMat) {
+    t.Helper()
+    const eps = 1e-6
+    r := rand.New(rand.NewSource(2))
+    tape := MakeTape()
+    vars := make([]*Var, len(inputs))
+    for i, m := range inputs {
+        vars[i] = tape.Var(m)
+    }
+    out := f(vars).Value()
+    w := random_mat(out.Rows(), out.Cols(), r, -1, 1)
+
+    loss, vars := weighted_loss(f, inputs, w)
+    loss.Backward()
+    for k, m := range inputs {
+        grad := vars[k].Grad()
+        for i := 0; i < m.Rows(); i++ {
+            for j := 0; j < m.Cols(); j++ {
+                v := m.GetElem(i, j)
+                m.SetElem(i, j, v+eps)
+                plus, _ := weighted_loss(f, inputs, w)
+                m.SetElem(i, j, v-eps)
+                minus, _ := weighted_loss(f, inputs, w)
+                m.SetElem(i, j, v)
+                numeric := (plus.Value().GetElem(0, 0) - minus.Value().GetElem(0, 0))/(2*eps)
+                analytic := grad.GetElem(i, j)
+                if d := math.Abs(numeric-analytic); d > 1e-7 && d > 1e-6*math.Max(math.Abs(numeric), math.Abs(analytic)) {
+                    t.Errorf("%s: input %d (%d, %d): backward %g, numeric %g", name, k, i, j, analytic, numeric)
+                }
+            }
+        }
+    }
+}
+
+func TestOpGradients(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+    unary := func(op func(v *Var) *Var) func(in []*Var) *Var {
+        return func(in []*Var) *Var { return op(in[0]) }
+    }
+    check_grad(t, "Mul", func(in []*Var) *Var { return in[0].Mul(in[1]) },
+               random_mat(3, 4, r, -1, 1), random_mat(4, 2, r, -1, 1))
+    check_grad(t, "AddCol", func(in []*Var) *Var { return in[0].AddCol(in[1]) },
+               random_mat(3, 4, r, -1, 1), random_mat(3, 1, r, -1, 1))
+    check_grad(t, "Add", func(in []*Var) *Var { return in[0].Add(in[1]) },
+               random_mat(3, 2, r, -1, 1), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "Sub", func(in []*Var) *Var { return in[0].Sub(in[1]) },
+               random_mat(3, 2, r, -1, 1), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "HadMul", func(in []*Var) *Var { return in[0].HadMul(in[1]) },
+               random_mat(3, 2, r, -1, 1), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "Tanh", unary((*Var).Tanh), random_mat(3, 2, r, -2, 2))
+    check_grad(t, "Sigmoid", unary((*Var).Sigmoid), random_mat(3, 2, r, -2, 2))
+    check_grad(t, "ReLU", unary((*Var).ReLU), away_from_zero(3, 2, r))
+    check_grad(t, "Softmax", unary((*Var).Softmax), random_mat(4, 3, r, -2, 2))
+    check_grad(t, "Log", unary((*Var).Log), random_mat(3, 2, r, 0.5, 2))
+    check_grad(t, "Exp", unary((*Var).Exp), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "Square", unary((*Var).Square), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "Sum", unary((*Var).Sum), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "SumCols", unary((*Var).SumCols), random_mat(3, 4, r, -1, 1))
+    check_grad(t, "Transpose", unary((*Var).Transpose), random_mat(3, 2, r, -1, 1))
+    check_grad(t, "Scale", unary(func(v *Var) *Var { return v.Scale(-2.5) }), random_mat(3, 2, r, -1, 1))
+
+    // A whole layer, with x used twice
+    check_grad(t, "layer", func(in []*Var) *Var {
+        a := in[0].Mul(in[2]).AddCol(in[1]).Sigmoid()
+        return a.Sub(in[2].Transpose().Mul(in[2]).Scale(0.1)).Square()
+    }, random_mat(3, 4, r, -1, 1), random_mat(3, 1, r, -1, 1), random_mat(4, 3, r, -1, 1))
+}
+
+func check_mat(t *testing.T, what string, got, want *mottuMat.MottuMat) {
+    t.Helper()
+    for i := 0; i < want.Rows(); i++ {
+        for j := 0; j < want.Cols(); j++ {
+            if math.Abs(got.GetElem(i, j) - want.GetElem(i, j)) > 1e-12 {
+                t.Fatalf("%s (%d, %d) is %g, want %g", what, i, j, got.GetElem(i, j), want.GetElem(i, j))
+            }
+        }
+    }
+}
+
+// Leaf gradients add up over Backward calls until ZeroGrads, the gradients
+// of results don't
+func TestGradsAccumulate(t *testing.T) {
+    m := random_mat(2, 3, rand.New(rand.NewSource(1)), -1, 1)
+    tape := MakeTape()
+    w := tape.Var(m)
+    sq := w.Square()
+    loss := sq.Sum()
+
+    loss.Backward()
+    check_mat(t, "grad after one pass", w.Grad(), m.Scale(2))
+    loss.Backward()
+    check_mat(t, "grad after two passes", w.Grad(), m.Scale(4))
+    check_mat(t, "grad of an operation", sq.Grad(), m.ApplyFunc(func(float64) float64 { return 1 }))
+
+    tape.ZeroGrads()
+    check_mat(t, "grad after ZeroGrads", w.Grad(), m.Scale(0))
+    loss.Backward()
+    check_mat(t, "grad after ZeroGrads and a pass", w.Grad(), m.Scale(2))
+}
+
+func must_panic(t *testing.T, what string, f func()) {
+    t.Helper()
+    defer func() {
+        if recover() == nil {
+            t.Errorf("%s didn't panic", what)
+        }
+    }()
+    f()
+}
+
+// Reset clears the gradients, and Vars from before it can't be used
+func TestReset(t *testing.T) {
+    m := random_mat(2, 3, rand.New(rand.NewSource(1)), -1, 1)
+    tape := MakeTape()
+    w := tape.Var(m)
+    loss := w.Square().Sum()
+    loss.Backward()
+
+    tape.Reset()
+    check_mat(t, "grad after Reset", w.Grad(), m.Scale(0))
+    must_panic(t, "an operation on a Var from before Reset", func() { w.Exp() })
+    must_panic(t, "Backward from before Reset", loss.Backward)
+
+    // Put back on the tape, the parameter starts from scratch
+    w2 := tape.Var(m)
+    must_panic(t, "mixing Vars from before and after Reset", func() { w2.Add(w) })
+    w2.Scale(3).Sum().Backward()
+    check_mat(t, "grad of the parameter added again", w2.Grad(), m.ApplyFunc(func(float64) float64 { return 3 }))
+    if w.Grad().GetElem(0, 0) != 0 {
+        t.Error("the Var from before Reset got a gradient")
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "fmt"
+)
+
+func run_eval(args []string) int {
+    fs := new_flags("eval", "")
+    var data data_flags
+    data.register(fs, false)
+    model_name := fs.String("model", "", "saved network to evaluate")
+    k := fs.Int("topk", 3, "also count a sample as correct when its digit is in the top `k`")
+    show := fs.Int("misclassified", 10, "list up to `n` misclassified test images")
+    if code := parse_flags(fs, args); code >= 0 {
+        return code
+    }
+    if fs.NArg() > 0 {
+        return usage_error(fs, "unexpected argument %q", fs.Arg(0))
+    }
+
+    mn, err := load_model(*model_name)
+    if err != nil {
+        return fail(err)
+    }
+    if *k < 1 || *k > mn.Model().OutputSize() {
+        return usage_error(fs, "topk must be between 1 and %d", mn.Model().OutputSize())
+    }
+    test_data, err := data.load_test()
+    if err != nil {
+        return fail(fmt.Errorf("loading test data: %w", err))
+    }
+    if err := check_input(mn, test_data); err != nil {
+        return fail(err)
+    }
+
+    e, err := mn.EvaluateDetailed(test_data, *k)
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Print(e)
+    fmt.Printf("macro F1 %.4f\n", e.MacroF1())
+    if *show > 0 && len(e.Misclassified) > 0 {
+        fmt.Printf("%d misclassified, the first ones:", len(e.Misclassified))
+        for i, idx := range e.Misclassified {
+            if i == *show {
+                break
+            }
+            fmt.Print(" ", idx)
+        }
+        fmt.Println()
+    }
+    return exitOK
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "NeuralNetworks/DigRec/network"
+)
+
+func run_inspect(args []string) int {
+    fs := new_flags("inspect", "[model]")
+    model_name := fs.String("model", "", "saved network to describe")
+    if code := parse_flags(fs, args); code >= 0 {
+        return code
+    }
+    if *model_name == "" && fs.NArg() == 1 {
+        *model_name = fs.Arg(0)
+    } else if fs.NArg() > 0 {
+        return usage_error(fs, "unexpected argument %q", fs.Arg(0))
+    }
+
+    mn, err := load_model(*model_name)
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Printf("%s: %d inputs, %d outputs, %s cost\n",
+               *model_name, mn.InputSize(), mn.Model().OutputSize(), mn.Cost().Name())
+    total := 0
+    for i, l := range mn.Model().Layers() {
+        count := 0
+        for _, p := range l.Params() {
+            count += p.Value.Rows()*p.Value.Cols()
+        }
+        total += count
+        fmt.Printf("%3d  %-40s %8d params\n", i, describe(l), count)
+    }
+    fmt.Printf("     %-40s %8d params\n", "total", total)
+    return exitOK
+}
+
+// describe returns a one line summary of a layer
+func describe(l network.Layer) string {
+    switch l := l.(type) {
+    case *network.Dense:
+        return fmt.Sprintf("dense %d -> %d", l.InputSize(), l.OutputSize())
+    case *network.ActivationLayer:
+        return fmt.Sprintf("%s (%d)", l.Activation().Name(), l.InputSize())
+    case *network.Dropout:
+        return fmt.Sprintf("dropout keep %g (%d)", l.Keep(), l.InputSize())
+    case *network.BatchNorm:
+        return fmt.Sprintf("batch norm (%d)", l.InputSize())
+    case *network.Conv2D:
+        return fmt.Sprintf("conv2d %d -> %v", l.InputSize(), l.OutputVolume())
+    case *network.Pool:
+        return fmt.Sprintf("pool %d -> %v", l.InputSize(), l.OutputVolume())
+    case *network.Flatten:
+        return fmt.Sprintf("flatten (%d)", l.InputSize())
+    }
+    return fmt.Sprintf("%T %d -> %d", l, l.InputSize(), l.OutputSize())
+}
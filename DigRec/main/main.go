@@ -1,34 +1,200 @@
 package main
 
 import (
-    "os"
+    "errors"
+    "flag"
     "fmt"
+    "io"
+    "os"
+    "strings"
     "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
     "NeuralNetworks/DigRec/network"
 )
 
+// Exit codes
+const (
+    exitOK = 0
+    exitError = 1 // the command failed, e.g. a file couldn't be read
+    exitUsage = 2 // bad command line
+)
+
+type command struct {
+    name string
+    summary string
+    run func(args []string) int
+}
+
+var commands []command
+
+func init() {
+    commands = []command{
+        {"train", "train a network on MNIST and optionally save it", run_train},
+        {"eval", "evaluate a saved network on the MNIST test set", run_eval},
+        {"predict", "classify images with a saved network", run_predict},
+        {"inspect", "describe a saved network", run_inspect},
+        {"serve", "serve predictions of a saved network over HTTP", run_serve},
+    }
+}
+
+// model is the part of a mottuNet the commands use
+type model interface {
+    SGD(training_data *mnist.Set, epochs int, mini_batch_size int, eta float64)
+    Evaluate(test_data *mnist.Set) int
+    EvaluateDetailed(test_data *mnist.Set, k int) (*network.Evaluation, error)
+    PredictMat(x *mottuMat.MottuMat, k int) ([]*network.Prediction, error)
+    ImageSize() (int, int, error)
+    SaveFile(name string) error
+    Model() *network.Sequential
+    Cost() network.Cost
+    InputSize() int
+}
+
 func main() {
-    args := os.Args
+    os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+    if len(args) == 0 {
+        usage(os.Stderr)
+        return exitUsage
+    }
+    switch args[0] {
+    case "help", "-h", "-help", "--help":
+        usage(os.Stdout)
+        return exitOK
+    }
+    for _, c := range commands {
+        if c.name == args[0] {
+            return c.run(args[1:])
+        }
+    }
+
+    // Before there were commands: DigRec <Path To Dir with MNIST> [Path To Save Model]
+    if len(args) <= 2 {
+        if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+            train_args := []string{"-data", args[0]}
+            if len(args) == 2 {
+                train_args = append(train_args, "-o", args[1])
+            }
+            return run_train(train_args)
+        }
+    }
+    fmt.Fprintf(os.Stderr, "DigRec: unknown command %q\n", args[0])
+    usage(os.Stderr)
+    return exitUsage
+}
+
+func usage(w io.Writer) {
+    fmt.Fprintln(w, "Usage: DigRec <command> [flags]")
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Commands:")
+    for _, c := range commands {
+        fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+    }
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Run \"DigRec <command> -h\" for the flags of a command.")
+}
+
+// ==================== helpers ===============
+
+// new_flags returns the flag set of a command. Parse errors are returned
+// rather than exiting, see parse_flags.
+func new_flags(name, args_usage string) *flag.FlagSet {
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), strings.TrimSpace("Usage: DigRec " + name + " [flags] " + args_usage))
+        fs.PrintDefaults()
+    }
+    return fs
+}
+
+// parse_flags parses args and returns the exit code to stop with, or -1 to
+// carry on
+func parse_flags(fs *flag.FlagSet, args []string) int {
+    if err := fs.Parse(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return exitOK
+        }
+        return exitUsage
+    }
+    return -1
+}
+
+// usage_error reports a bad flag value
+func usage_error(fs *flag.FlagSet, format string, a ...interface{}) int {
+    fmt.Fprintf(fs.Output(), "DigRec %s: %s\n", fs.Name(), fmt.Sprintf(format, a...))
+    fs.Usage()
+    return exitUsage
+}
+
+// fail reports an error that ends the command
+func fail(err error) int {
+    fmt.Fprintln(os.Stderr, "DigRec:", err)
+    return exitError
+}
+
+// data_flags locate the MNIST files. The files in -data, gzipped or not,
+// are used unless a path is given for one of them.
+type data_flags struct {
+    dir string
+    train_images, train_labels string
+    test_images, test_labels string
+}
+
+func (d *data_flags) register(fs *flag.FlagSet, train bool) {
+    fs.StringVar(&d.dir, "data", ".", "`dir` holding the MNIST files")
+    if train {
+        fs.StringVar(&d.train_images, "train-images", "", "training images (default <data>/" + mnist.TrainImagesFile + ")")
+        fs.StringVar(&d.train_labels, "train-labels", "", "training labels (default <data>/" + mnist.TrainLabelsFile + ")")
+    }
+    fs.StringVar(&d.test_images, "test-images", "", "test images (default <data>/" + mnist.TestImagesFile + ")")
+    fs.StringVar(&d.test_labels, "test-labels", "", "test labels (default <data>/" + mnist.TestLabelsFile + ")")
+}
 
-    if len(args) < 2 {
-        fmt.Println("Usage: DigRec <Path To Dir with MNIST>")
-        return
+func (d *data_flags) file(name, def string) string {
+    if name != "" {
+        return name
     }
-    training_data, test_data, err := mnist.Load(args[1]) 
+    return mnist.FindFile(d.dir, def)
+}
+
+func (d *data_flags) load_train() (*mnist.Set, error) {
+    return mnist.ReadSet(d.file(d.train_images, mnist.TrainImagesFile), d.file(d.train_labels, mnist.TrainLabelsFile))
+}
+
+func (d *data_flags) load_test() (*mnist.Set, error) {
+    return mnist.ReadSet(d.file(d.test_images, mnist.TestImagesFile), d.file(d.test_labels, mnist.TestLabelsFile))
+}
+
+// load_model reads a saved network
+func load_model(name string) (model, error) {
+    if name == "" {
+        return nil, errors.New("no model given, use -model")
+    }
+    mn, err := network.LoadFile(name)
     if err != nil {
-        fmt.Println("Encountered this error in loading MNIST data: ",
-                    err.Error())
-        return
-    }
-    sizes := []int{784, 30, 10}
-    mn := network.MakeMottuNet(sizes)
-    fmt.Println("MottuNet is studying ... really really hard :p")
-    mn.SGD(training_data, 30, 10, 3.0)
-    fmt.Println("Test time for mottu net ...")
-    num_correct := mn.Evaluate(test_data)
-    fmt.Println("How did mottu net do? %d out of %d correct",
-                num_correct,
-                test_data.Count())
-    return
+        return nil, fmt.Errorf("loading %s: %w", name, err)
+    }
+    return mn, nil
+}
+
+// check_input makes sure the images of a data set fit the network
+func check_input(mn model, set *mnist.Set) error {
+    if set.NRow*set.NCol != mn.InputSize() {
+        return fmt.Errorf("images are %dx%d but the network takes %d inputs",
+                          set.NRow, set.NCol, mn.InputSize())
+    }
+    return nil
+}
 
+// split_list splits a comma separated flag value, dropping empty entries
+func split_list(s string) []string {
+    var retval []string
+    for _, f := range strings.Split(s, ",") {
+        if f = strings.TrimSpace(f); f != "" {
+            retval = append(retval, f)
+        }
+    }
+    return retval
 }
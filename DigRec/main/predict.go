@@ -0,0 +1,69 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+func run_predict(args []string) int {
+    fs := new_flags("predict", "index|image...")
+    var data data_flags
+    data.register(fs, false)
+    model_name := fs.String("model", "", "saved network to predict with")
+    k := fs.Int("topk", 3, "show the `k` most likely digits")
+    if code := parse_flags(fs, args); code >= 0 {
+        return code
+    }
+    if fs.NArg() == 0 {
+        return usage_error(fs, "give the indices of test images or PNG, JPEG or GIF files to classify")
+    }
+
+    mn, err := load_model(*model_name)
+    if err != nil {
+        return fail(err)
+    }
+
+    // Numbers are test images, anything else an image file. The test set is
+    // only read when it's needed.
+    var test_data *mnist.Set
+    for _, arg := range fs.Args() {
+        var image *mottuMat.MottuMat
+        label := ""
+        if idx, err := strconv.Atoi(arg); err == nil {
+            if test_data == nil {
+                if test_data, err = data.load_test(); err != nil {
+                    return fail(fmt.Errorf("loading test data: %w", err))
+                }
+                if err := check_input(mn, test_data); err != nil {
+                    return fail(err)
+                }
+            }
+            if idx < 0 || idx >= test_data.Count() {
+                return usage_error(fs, "bad test image index %q, there are %d", arg, test_data.Count())
+            }
+            image, _ = test_data.Get(idx)
+            label = fmt.Sprintf(", labelled %d", test_data.Label(idx))
+        } else {
+            if mn.InputSize() != mnist.Width*mnist.Height {
+                return fail(fmt.Errorf("image files are %dx%d but the network takes %d inputs",
+                                       mnist.Width, mnist.Height, mn.InputSize()))
+            }
+            if image, err = mnist.PreprocessFile(arg); err != nil {
+                return fail(fmt.Errorf("reading %s: %w", arg, err))
+            }
+        }
+        predictions, err := mn.PredictMat(image, *k)
+        if err != nil {
+            return usage_error(fs, "%v", err)
+        }
+        p := predictions[0]
+        fmt.Printf("%s: predicted %d%s, top", arg, p.Digit, label)
+        for _, c := range p.Top {
+            fmt.Printf(" %d (%.2f%%)", c.Class, 100*c.Probability)
+        }
+        fmt.Println()
+    }
+    return exitOK
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/server"
+)
+
+func run_serve(args []string) int {
+    fs := new_flags("serve", "")
+    model_name := fs.String("model", "", "saved network to serve")
+    addr := fs.String("addr", ":8080", "`address` to listen on")
+    max_body := fs.Int64("max-body", server.DefaultMaxBodyBytes, "largest request body in `bytes`")
+    max_batch := fs.Int("max-batch", server.DefaultMaxBatch, "most images in a batch request")
+    max_pixels := fs.Int("max-pixels", server.DefaultMaxImagePixels, "largest uploaded image in pixels")
+    max_upload := fs.Int("max-upload-pixels", server.DefaultMaxUploadPixels, "most pixels of all the images of a request")
+    k := fs.Int("topk", server.DefaultTopK, "most likely digits returned when a request doesn't say")
+    if code := parse_flags(fs, args); code >= 0 {
+        return code
+    }
+    if fs.NArg() > 0 {
+        return usage_error(fs, "unexpected argument %q", fs.Arg(0))
+    }
+    if *max_body <= 0 || *max_batch <= 0 || *max_pixels <= 0 || *max_upload <= 0 || *k <= 0 {
+        return usage_error(fs, "limits and topk must be positive")
+    }
+    if *max_pixels > mnist.MaxPixels {
+        return usage_error(fs, "max-pixels can be at most %d", mnist.MaxPixels)
+    }
+
+    mn, err := load_model(*model_name)
+    if err != nil {
+        return fail(err)
+    }
+    srv := &http.Server{
+        Addr: *addr,
+        Handler: server.MakeServer(mn, server.Config{
+            MaxBodyBytes: *max_body,
+            MaxBatch: *max_batch,
+            MaxImagePixels: *max_pixels,
+            MaxUploadPixels: *max_upload,
+            TopK: *k,
+        }),
+        ReadHeaderTimeout: 10*time.Second,
+        ReadTimeout: time.Minute,
+        WriteTimeout: time.Minute,
+    }
+
+    // Finish the requests being served on Ctrl-C or SIGTERM
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    done := make(chan error, 1)
+    go func() {
+        <-ctx.Done()
+        shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        done <- srv.Shutdown(shutdown)
+    }()
+
+    fmt.Printf("Serving %s on %s\n", *model_name, *addr)
+    if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+        return fail(err)
+    }
+    if err := <-done; err != nil {
+        return fail(err)
+    }
+    return exitOK
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "math/rand"
+    "strconv"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/network"
+)
+
+func run_train(args []string) int {
+    fs := new_flags("train", "")
+    var data data_flags
+    data.register(fs, true)
+    arch := fs.String("arch", "mlp", "network: mlp, dense layers given by -sizes, or lenet, convolutional")
+    sizes_str := fs.String("sizes", "784,30,10", "neurons in every layer of the mlp, input first")
+    acts_str := fs.String("activations", "", "activation of every non input layer of the mlp (default sigmoid)")
+    cost_str := fs.String("cost", "quadratic", "cost: quadratic, cross_entropy or log_likelihood (lenet defaults to log_likelihood)")
+    init_str := fs.String("init", "normal", "initializer of the mlp: normal, scaled_normal, xavier, he or uniform:a")
+    opt_str := fs.String("optimizer", "sgd", "optimizer, e.g. sgd, momentum:0.9, adam")
+    sched_str := fs.String("schedule", "none", "learning rate schedule, e.g. step:0.5,10 or cosine:10,2,0")
+    epochs := fs.Int("epochs", 30, "epochs to train for")
+    batch := fs.Int("batch", 10, "mini batch size")
+    eta := fs.Float64("eta", 3.0, "learning rate")
+    l1 := fs.Float64("l1", 0, "L1 regularization")
+    l2 := fs.Float64("l2", 0, "L2 regularization")
+    keep := fs.Float64("dropout", 1, "probability of keeping a hidden neuron while training")
+    batchnorm := fs.Bool("batchnorm", false, "batch normalize every hidden dense layer")
+    workers := fs.Int("workers", 1, "goroutines computing gradients, 0 for one per CPU")
+    vectorized := fs.Bool("vectorized", true, "backprop each worker's samples as one matrix, false for one sample at a time")
+    seed := fs.Int64("seed", network.DefaultSeed, "seed for initialization, shuffling and dropout")
+    validation := fs.Int("validation", 0, "hold out the last `n` training images to validate on after every epoch")
+    patience := fs.Int("patience", 0, "stop after this many epochs without validation improvement, 0 to never stop early")
+    out := fs.String("o", "", "save the trained network to this `file` (.json for JSON)")
+    if code := parse_flags(fs, args); code >= 0 {
+        return code
+    }
+    if fs.NArg() > 0 {
+        return usage_error(fs, "unexpected argument %q", fs.Arg(0))
+    }
+    given := map[string]bool{}
+    fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+    switch *arch {
+    case "mlp":
+    case "lenet":
+        if given["sizes"] || given["activations"] || given["init"] {
+            return usage_error(fs, "sizes, activations and init only apply to the mlp")
+        }
+    default:
+        return usage_error(fs, "unknown arch %q, want mlp or lenet", *arch)
+    }
+
+    var sizes []int
+    for _, f := range split_list(*sizes_str) {
+        s, err := strconv.Atoi(f)
+        if err != nil || s <= 0 {
+            return usage_error(fs, "bad layer size %q", f)
+        }
+        sizes = append(sizes, s)
+    }
+    if len(sizes) < 2 {
+        return usage_error(fs, "need at least an input and an output layer")
+    }
+    var acts []network.Activation
+    for _, name := range split_list(*acts_str) {
+        act, err := network.ParseActivation(name)
+        if err != nil {
+            return usage_error(fs, "%v", err)
+        }
+        acts = append(acts, act)
+    }
+    if len(acts) > 0 && len(acts) != len(sizes)-1 {
+        return usage_error(fs, "%d activations given for %d non input layers", len(acts), len(sizes)-1)
+    }
+    cost, err := network.ParseCost(*cost_str)
+    if err != nil {
+        return usage_error(fs, "%v", err)
+    }
+    init, err := network.ParseInitializer(*init_str)
+    if err != nil {
+        return usage_error(fs, "%v", err)
+    }
+    opt, err := network.ParseOptimizer(*opt_str)
+    if err != nil {
+        return usage_error(fs, "%v", err)
+    }
+    sched, err := network.ParseSchedule(*sched_str)
+    if err != nil {
+        return usage_error(fs, "%v", err)
+    }
+    if *epochs <= 0 || *batch <= 0 || *eta <= 0 {
+        return usage_error(fs, "epochs, batch and eta must be positive")
+    }
+    if *keep <= 0 || *keep > 1 {
+        return usage_error(fs, "dropout keep probability must be in (0, 1]")
+    }
+    if *validation < 0 || *patience < 0 {
+        return usage_error(fs, "validation and patience can't be negative")
+    }
+
+    training_data, err := data.load_train()
+    if err != nil {
+        return fail(fmt.Errorf("loading training data: %w", err))
+    }
+    test_data, err := data.load_test()
+    if err != nil {
+        return fail(fmt.Errorf("loading test data: %w", err))
+    }
+    if *validation >= training_data.Count() {
+        return usage_error(fs, "can't hold out %d of %d training images", *validation, training_data.Count())
+    }
+
+    var mn trainable
+    switch {
+    case *arch == "lenet":
+        // Two rounds of 5x5 convolution and 2x2 pooling leave nothing of
+        // smaller images
+        if training_data.NRow < 16 || training_data.NCol < 16 {
+            return fail(fmt.Errorf("lenet needs images of at least 16x16, these are %dx%d",
+                                   training_data.NRow, training_data.NCol))
+        }
+        in := network.Volume{Channels: 1, Height: training_data.NRow, Width: training_data.NCol}
+        mn = network.MakeLeNet(in, rand.New(rand.NewSource(*seed)))
+    case len(acts) > 0:
+        mn = network.MakeMottuNetWithActivations(sizes, acts)
+    default:
+        mn = network.MakeMottuNet(sizes)
+    }
+    if err := check_input(mn, training_data); err != nil {
+        return fail(err)
+    }
+    if *arch == "mlp" {
+        mn.Initialize(init, rand.New(rand.NewSource(*seed)))
+    }
+    mn.SetRand(rand.New(rand.NewSource(*seed)))
+    if *arch == "mlp" || given["cost"] {
+        mn.SetCost(cost)
+    }
+    mn.SetOptimizer(opt)
+    mn.SetSchedule(sched)
+    mn.SetRegularization(*l1, *l2)
+    mn.SetWorkers(*workers)
+    mn.SetVectorized(*vectorized)
+    dense := 0
+    for _, l := range mn.Model().Layers() {
+        if _, ok := l.(*network.Dense); ok {
+            dense++
+        }
+    }
+    for layer := 1; layer < dense; layer++ {
+        mn.SetDropout(layer, *keep)
+        if *batchnorm {
+            mn.SetBatchNorm(layer, 0.9)
+        }
+    }
+    if *validation > 0 {
+        n := training_data.Count() - *validation
+        validation_data := &mnist.Set{
+            NRow: training_data.NRow,
+            NCol: training_data.NCol,
+            Images: training_data.Images[n:],
+            ExpOut: training_data.ExpOut[n:],
+        }
+        training_data = &mnist.Set{
+            NRow: training_data.NRow,
+            NCol: training_data.NCol,
+            Images: training_data.Images[:n],
+            ExpOut: training_data.ExpOut[:n],
+        }
+        mn.SetValidationData(validation_data)
+        if *patience > 0 {
+            mn.SetEarlyStopping(&network.EarlyStopping{Monitor: network.MonitorAccuracy, Patience: *patience})
+        }
+    }
+    mn.SetHooks(progress{})
+
+    fmt.Println("MottuNet is studying ... really really hard :p")
+    mn.SGD(training_data, *epochs, *batch, *eta)
+    fmt.Println("Test time for mottu net ...")
+    e, err := mn.EvaluateDetailed(test_data, 1)
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Printf("How did mottu net do? %d out of %d correct (%.2f%%)\n",
+               e.NumCorrect,
+               e.Count,
+               100*e.Accuracy)
+    if *out != "" {
+        if err := mn.SaveFile(*out); err != nil {
+            return fail(fmt.Errorf("could not save mottu net: %w", err))
+        }
+        fmt.Println("Saved mottu net to", *out)
+    }
+    return exitOK
+}
+
+// trainable is the part of a freshly made mottuNet train sets up
+type trainable interface {
+    model
+    Initialize(init network.Initializer, r *rand.Rand)
+    SetRand(r *rand.Rand)
+    SetCost(c network.Cost)
+    SetOptimizer(opt network.Optimizer)
+    SetSchedule(s network.Schedule)
+    SetRegularization(l1, l2 float64)
+    SetWorkers(n int)
+    SetVectorized(on bool)
+    SetDropout(layer int, keep float64)
+    SetBatchNorm(layer int, momentum float64)
+    SetValidationData(data *mnist.Set)
+    SetEarlyStopping(es *network.EarlyStopping)
+    SetHooks(h network.Hooks)
+}
+
+// progress prints a line per epoch
+type progress struct {
+    network.BaseHooks
+}
+
+func (progress) OnEpochEnd(stats network.EpochStats) bool {
+    fmt.Printf("Epoch %d: loss %.4f, eta %.4g, took %v", stats.Epoch, stats.Loss, stats.Eta, stats.EpochTime)
+    if stats.Validated {
+        fmt.Printf(", validation %.2f%% (loss %.4f)", 100*stats.Accuracy, stats.ValidationLoss)
+    }
+    if stats.EarlyStopped {
+        fmt.Print(", stopping early")
+    }
+    fmt.Println()
+    return false
+}
@@ -0,0 +1,292 @@
+package mnist
+
+/*
+IDX is the file format the MNIST data is distributed in, see
+http://yann.lecun.com/exdb/mnist/. A file is
+
+    magic       uint32: two zero bytes, the data type and the number of
+                dimensions
+    dims        uint32 each, the size of every dimension
+    data        the values, big endian, the last dimension changing fastest
+
+and is often gzipped.
+*/
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "strings"
+)
+
+// IDXType is the type of the values in an IDX file, the third byte of its
+// magic number
+type IDXType byte
+
+const (
+    IDXUByte IDXType = 0x08
+    IDXSByte IDXType = 0x09
+    IDXShort IDXType = 0x0B
+    IDXInt IDXType = 0x0C
+    IDXFloat IDXType = 0x0D
+    IDXDouble IDXType = 0x0E
+)
+
+// Size returns the bytes a value takes, 0 for an unknown type
+func (t IDXType) Size() int {
+    switch t {
+    case IDXUByte, IDXSByte:
+        return 1
+    case IDXShort:
+        return 2
+    case IDXInt, IDXFloat:
+        return 4
+    case IDXDouble:
+        return 8
+    }
+    return 0
+}
+
+func (t IDXType) String() string {
+    switch t {
+    case IDXUByte:
+        return "ubyte"
+    case IDXSByte:
+        return "sbyte"
+    case IDXShort:
+        return "short"
+    case IDXInt:
+        return "int"
+    case IDXFloat:
+        return "float"
+    case IDXDouble:
+        return "double"
+    }
+    return fmt.Sprintf("IDXType(0x%02x)", byte(t))
+}
+
+// IDX is the content of an IDX file. The values are kept the way they are
+// stored, use Float and Int to get at them.
+type IDX struct {
+    Type IDXType
+    Dims []int
+    Data []byte
+}
+
+// MakeIDX returns an IDX of the given type and dimensions with every value 0
+func MakeIDX(t IDXType, dims ...int) *IDX {
+    if t.Size() == 0 {
+        panic(fmt.Sprintf("mnist: unknown IDX type %v", t))
+    }
+    if len(dims) == 0 || len(dims) > 255 {
+        panic(fmt.Sprintf("mnist: IDX needs 1 to 255 dimensions, got %d", len(dims)))
+    }
+    for _, d := range dims {
+        if d < 0 || d > math.MaxUint32 {
+            panic(fmt.Sprintf("mnist: bad IDX dimension %d", d))
+        }
+    }
+    size, ok := idx_bytes(t, dims)
+    if !ok {
+        panic(fmt.Sprintf("mnist: IDX dimensions %v too large", dims))
+    }
+    return &IDX{Type: t, Dims: append([]int(nil), dims...), Data: make([]byte, size)}
+}
+
+// maxIDXBytes bounds the data of an IDX, well above anything MNIST like
+const maxIDXBytes = math.MaxInt32*8
+
+// idx_bytes returns the bytes the values of an IDX of type t with the given
+// dimensions take, and false if that is over maxIDXBytes. Every factor is
+// checked before multiplying so the product can't wrap around.
+func idx_bytes(t IDXType, dims []int) (int, bool) {
+    size := uint64(t.Size())
+    for _, d := range dims {
+        if d < 0 || d != 0 && size > maxIDXBytes/uint64(d) {
+            return 0, false
+        }
+        size *= uint64(d)
+    }
+    return int(size), true
+}
+
+// Len returns the number of values, the product of the dimensions
+func (x *IDX) Len() int {
+    return len(x.Data)/x.Type.Size()
+}
+
+// Magic returns the magic number the file starts with
+func (x *IDX) Magic() uint32 {
+    return uint32(x.Type)<<8 | uint32(len(x.Dims))
+}
+
+// Float returns the ith value, counting in storage order
+func (x *IDX) Float(i int) float64 {
+    switch x.Type {
+    case IDXFloat:
+        return float64(math.Float32frombits(binary.BigEndian.Uint32(x.Data[4*i:])))
+    case IDXDouble:
+        return math.Float64frombits(binary.BigEndian.Uint64(x.Data[8*i:]))
+    }
+    return float64(x.Int(i))
+}
+
+// Int returns the ith value, counting in storage order. Floating point
+// values are truncated.
+func (x *IDX) Int(i int) int {
+    switch x.Type {
+    case IDXUByte:
+        return int(x.Data[i])
+    case IDXSByte:
+        return int(int8(x.Data[i]))
+    case IDXShort:
+        return int(int16(binary.BigEndian.Uint16(x.Data[2*i:])))
+    case IDXInt:
+        return int(int32(binary.BigEndian.Uint32(x.Data[4*i:])))
+    }
+    return int(x.Float(i))
+}
+
+// SetFloat sets the ith value, converting v to the type of x. Integer types
+// round v and saturate at their limits.
+func (x *IDX) SetFloat(i int, v float64) {
+    switch x.Type {
+    case IDXUByte:
+        x.Data[i] = byte(clamp_round(v, 0, math.MaxUint8))
+    case IDXSByte:
+        x.Data[i] = byte(int8(clamp_round(v, math.MinInt8, math.MaxInt8)))
+    case IDXShort:
+        binary.BigEndian.PutUint16(x.Data[2*i:], uint16(int16(clamp_round(v, math.MinInt16, math.MaxInt16))))
+    case IDXInt:
+        binary.BigEndian.PutUint32(x.Data[4*i:], uint32(int32(clamp_round(v, math.MinInt32, math.MaxInt32))))
+    case IDXFloat:
+        binary.BigEndian.PutUint32(x.Data[4*i:], math.Float32bits(float32(v)))
+    case IDXDouble:
+        binary.BigEndian.PutUint64(x.Data[8*i:], math.Float64bits(v))
+    }
+}
+
+func clamp_round(v, lo, hi float64) float64 {
+    return math.Max(lo, math.Min(hi, math.Round(v)))
+}
+
+// ==================== Reading ===============
+
+// ReadIDX reads an IDX file, gzipped or not
+func ReadIDX(r io.Reader) (*IDX, error) {
+    br := bufio.NewReader(r)
+    // gzip streams start with 0x1f 0x8b, IDX files with two zero bytes
+    if head, err := br.Peek(2); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+        z, err := gzip.NewReader(br)
+        if err != nil {
+            return nil, err
+        }
+        defer z.Close()
+        return read_idx(z)
+    }
+    return read_idx(br)
+}
+
+// ReadIDXFile reads the named IDX file, gzipped or not
+func ReadIDXFile(name string) (*IDX, error) {
+    f, err := os.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    x, err := ReadIDX(f)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    return x, nil
+}
+
+// Errors about the format wrap os.ErrInvalid
+func read_idx(r io.Reader) (*IDX, error) {
+    var magic uint32
+    if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+        return nil, err
+    }
+    t, ndims := IDXType(magic>>8), int(magic&0xff)
+    if magic>>16 != 0 || t.Size() == 0 || ndims == 0 {
+        return nil, fmt.Errorf("mnist: magic number %08x isn't IDX: %w", magic, os.ErrInvalid)
+    }
+    dims32 := make([]uint32, ndims)
+    if err := binary.Read(r, binary.BigEndian, dims32); err != nil {
+        return nil, err
+    }
+    dims := make([]int, ndims)
+    for i, d := range dims32 {
+        dims[i] = int(d)
+    }
+    size, ok := idx_bytes(t, dims)
+    if !ok {
+        return nil, fmt.Errorf("mnist: IDX dimensions %v too large: %w", dims32, os.ErrInvalid)
+    }
+
+    // Read as the data comes rather than allocating what the header claims
+    var buf bytes.Buffer
+    if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return nil, err
+    }
+    if buf.Len() != size {
+        return nil, fmt.Errorf("mnist: read %d bytes of IDX data, want %d: %w", buf.Len(), size, os.ErrInvalid)
+    }
+    return &IDX{Type: t, Dims: dims, Data: buf.Bytes()}, nil
+}
+
+// ==================== Writing ===============
+
+// WriteIDX writes x in the IDX format, uncompressed
+func WriteIDX(w io.Writer, x *IDX) error {
+    if x.Type.Size() == 0 || len(x.Dims) == 0 || len(x.Dims) > 255 {
+        return fmt.Errorf("mnist: can't write IDX of type %v with %d dimensions: %w", x.Type, len(x.Dims), os.ErrInvalid)
+    }
+    if size, ok := idx_bytes(x.Type, x.Dims); !ok || size != len(x.Data) {
+        return fmt.Errorf("mnist: IDX dimensions %v don't match %d bytes of data: %w", x.Dims, len(x.Data), os.ErrInvalid)
+    }
+    header := make([]uint32, 1+len(x.Dims))
+    header[0] = x.Magic()
+    for i, d := range x.Dims {
+        header[i+1] = uint32(d)
+    }
+    if err := binary.Write(w, binary.BigEndian, header); err != nil {
+        return err
+    }
+    _, err := w.Write(x.Data)
+    return err
+}
+
+// WriteIDXFile writes x to the named file, gzipped if the name ends in .gz
+func WriteIDXFile(name string, x *IDX) (err error) {
+    f, err := os.Create(name)
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+    }()
+    bw := bufio.NewWriter(f)
+    if strings.HasSuffix(name, ".gz") {
+        z := gzip.NewWriter(bw)
+        if err = WriteIDX(z, x); err != nil {
+            return err
+        }
+        if err = z.Close(); err != nil {
+            return err
+        }
+    } else if err = WriteIDX(bw, x); err != nil {
+        return err
+    }
+    return bw.Flush()
+}
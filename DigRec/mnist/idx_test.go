@@ -0,0 +1,218 @@
+package mnist
+
+import (
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "io"
+    "math"
+    "os"
+    "path"
+    "testing"
+)
+
+// Values every type holds exactly, with negatives for the signed ones
+var idxValues = map[IDXType][]float64{
+    IDXUByte: {0, 1, 127, 128, 255, 42},
+    IDXSByte: {0, -1, 127, -128, 5, -42},
+    IDXShort: {0, -1, math.MaxInt16, math.MinInt16, 300, -300},
+    IDXInt: {0, -1, math.MaxInt32, math.MinInt32, 70000, -70000},
+    IDXFloat: {0, -1.5, 0.25, 3e10, -1e-3, 42},
+    IDXDouble: {0, -1.5, 0.1, math.MaxFloat64, -math.SmallestNonzeroFloat64, 42},
+}
+
+func test_idx(t IDXType) *IDX {
+    x := MakeIDX(t, 2, 3)
+    for i, v := range idxValues[t] {
+        x.SetFloat(i, v)
+    }
+    return x
+}
+
+func check_idx(t *testing.T, got *IDX, want_type IDXType) {
+    t.Helper()
+    if got.Type != want_type || len(got.Dims) != 2 || got.Dims[0] != 2 || got.Dims[1] != 3 {
+        t.Fatalf("read %v with dimensions %v, want %v with [2 3]", got.Type, got.Dims, want_type)
+    }
+    for i, v := range idxValues[want_type] {
+        want := v
+        if want_type == IDXFloat {
+            want = float64(float32(v))
+        }
+        if got.Float(i) != want {
+            t.Errorf("%v value %d is %g, want %g", want_type, i, got.Float(i), want)
+        }
+    }
+}
+
+func TestIDXRoundTrip(t *testing.T) {
+    for typ := range idxValues {
+        var buf bytes.Buffer
+        if err := WriteIDX(&buf, test_idx(typ)); err != nil {
+            t.Fatalf("%v: %v", typ, err)
+        }
+        if buf.Len() != 4+2*4+6*typ.Size() {
+            t.Errorf("%v: wrote %d bytes, want %d", typ, buf.Len(), 4+2*4+6*typ.Size())
+        }
+        x, err := ReadIDX(&buf)
+        if err != nil {
+            t.Fatalf("%v: %v", typ, err)
+        }
+        check_idx(t, x, typ)
+    }
+}
+
+func TestIDXInt(t *testing.T) {
+    x := test_idx(IDXShort)
+    for i, v := range idxValues[IDXShort] {
+        if x.Int(i) != int(v) {
+            t.Errorf("Int(%d) is %d, want %d", i, x.Int(i), int(v))
+        }
+    }
+    // Integer types saturate
+    x.SetFloat(0, 1e6)
+    x.SetFloat(1, -1e6)
+    if x.Int(0) != math.MaxInt16 || x.Int(1) != math.MinInt16 {
+        t.Errorf("saturated to %d and %d", x.Int(0), x.Int(1))
+    }
+}
+
+// Files ending in .gz are written gzipped, and both read back the same
+func TestIDXFileGzip(t *testing.T) {
+    dir := t.TempDir()
+    x := test_idx(IDXInt)
+    for _, name := range []string{"x.idx", "x.idx.gz"} {
+        file := path.Join(dir, name)
+        if err := WriteIDXFile(file, x); err != nil {
+            t.Fatal(err)
+        }
+        raw, err := os.ReadFile(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        gzipped := raw[0] == 0x1f && raw[1] == 0x8b
+        if gzipped != (path.Ext(name) == ".gz") {
+            t.Errorf("%s: gzipped is %v", name, gzipped)
+        }
+        got, err := ReadIDXFile(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        check_idx(t, got, IDXInt)
+    }
+
+    // Detection goes by the content, not the name
+    var buf bytes.Buffer
+    z := gzip.NewWriter(&buf)
+    WriteIDX(z, x)
+    z.Close()
+    file := path.Join(dir, "gzipped.idx")
+    os.WriteFile(file, buf.Bytes(), 0644)
+    got, err := ReadIDXFile(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check_idx(t, got, IDXInt)
+}
+
+func TestReadIDXErrors(t *testing.T) {
+    var buf bytes.Buffer
+    WriteIDX(&buf, test_idx(IDXDouble))
+    good := buf.Bytes()
+
+    tests := []struct {
+        name string
+        data []byte
+        want error
+    }{
+        {"empty", nil, io.EOF},
+        {"bad magic", []byte{1, 2, 8, 1, 0, 0, 0, 1, 0}, os.ErrInvalid},
+        {"unknown type", []byte{0, 0, 0x0A, 1, 0, 0, 0, 1, 0}, os.ErrInvalid},
+        {"no dimensions", []byte{0, 0, 8, 0}, os.ErrInvalid},
+        {"huge dimensions", []byte{0, 0, 0x0E, 2, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, os.ErrInvalid},
+        // 2^17 * 2^16 * 2^31 wraps around to 0 in 64 bits
+        {"wrapping dimensions", wrapping_header, os.ErrInvalid},
+        {"truncated header", good[:6], io.ErrUnexpectedEOF},
+        {"truncated data", good[:len(good)-1], io.ErrUnexpectedEOF},
+        {"truncated gzip", []byte{0x1f, 0x8b, 8}, io.ErrUnexpectedEOF},
+    }
+    for _, test := range tests {
+        if _, err := ReadIDX(bytes.NewReader(test.data)); !errors.Is(err, test.want) {
+            t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+        }
+    }
+
+    if err := WriteIDX(io.Discard, &IDX{Type: IDXUByte, Dims: []int{3}, Data: []byte{1}}); !errors.Is(err, os.ErrInvalid) {
+        t.Errorf("writing mismatched data: got %v, want os.ErrInvalid", err)
+    }
+}
+
+// The header of a ubyte file whose size overflows uint64 to 0
+var wrapping_header = []byte{0, 0, 8, 3, 0, 2, 0, 0, 0, 1, 0, 0, 0x80, 0, 0, 0}
+
+func TestIDXOverflow(t *testing.T) {
+    if _, _, _, err := readImageFile(bytes.NewReader(wrapping_header)); !errors.Is(err, os.ErrInvalid) {
+        t.Errorf("images with wrapping dimensions: got %v, want os.ErrInvalid", err)
+    }
+    x := &IDX{Type: IDXUByte, Dims: []int{1 << 17, 1 << 16, 1 << 31}}
+    if err := WriteIDX(io.Discard, x); !errors.Is(err, os.ErrInvalid) {
+        t.Errorf("writing wrapping dimensions: got %v, want os.ErrInvalid", err)
+    }
+    defer func() {
+        if recover() == nil {
+            t.Error("MakeIDX with wrapping dimensions didn't panic")
+        }
+    }()
+    MakeIDX(IDXUByte, 1 << 17, 1 << 16, 1 << 31)
+}
+
+// ==================== MNIST files ===============
+
+// write_set writes n made up images and labels in the MNIST format
+func write_set(t *testing.T, images, labels string, n int) {
+    t.Helper()
+    x := MakeIDX(IDXUByte, n, Height, Width)
+    y := MakeIDX(IDXUByte, n)
+    for i := 0; i < n; i++ {
+        x.Data[i*Width*Height + i] = 255
+        y.Data[i] = byte(i % 10)
+    }
+    if err := WriteIDXFile(images, x); err != nil {
+        t.Fatal(err)
+    }
+    if err := WriteIDXFile(labels, y); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Load takes the files gzipped or unpacked, even mixed
+func TestLoadUnpacked(t *testing.T) {
+    dir := t.TempDir()
+    write_set(t, path.Join(dir, TrainImagesFile), path.Join(dir, "train-labels-idx1-ubyte"), 12)
+    write_set(t, path.Join(dir, "t10k-images-idx3-ubyte"), path.Join(dir, "t10k-labels-idx1-ubyte"), 3)
+
+    if got := FindFile(dir, TrainImagesFile); got != path.Join(dir, TrainImagesFile) {
+        t.Errorf("FindFile picked %s for the gzipped images", got)
+    }
+    if got := FindFile(dir, TestLabelsFile); got != path.Join(dir, "t10k-labels-idx1-ubyte") {
+        t.Errorf("FindFile picked %s for the unpacked labels", got)
+    }
+
+    train, test, err := Load(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if train.Count() != 12 || test.Count() != 3 || train.NRow != Height || train.NCol != Width {
+        t.Fatalf("loaded %d and %d images of %dx%d", train.Count(), test.Count(), train.NRow, train.NCol)
+    }
+    for i := 0; i < train.Count(); i++ {
+        if train.Label(i) != i%10 || train.Images[i].GetElem(i, 0) != 1 {
+            t.Errorf("image %d: label %d, pixel %g", i, train.Label(i), train.Images[i].GetElem(i, 0))
+        }
+    }
+
+    os.Remove(path.Join(dir, "t10k-labels-idx1-ubyte"))
+    if _, _, err := Load(dir); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("missing labels: got %v, want os.ErrNotExist", err)
+    }
+}
@@ -0,0 +1,54 @@
+package mnist
+
+import (
+    "image"
+    "image/color"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// ImageVec converts img to the format of Set.Images: a width*height col vec
+// of gray levels scaled to [0, 1], row by row. Like RawImage, bright pixels
+// are the digit and dark ones the background, so img should already be laid
+// out like an MNIST digit; pass other pictures through Preprocess first. An
+// image of a different size is resized, every target pixel taking the
+// average of the pixels it covers.
+func ImageVec(img image.Image, width, height int) *mottuMat.MottuMat {
+    vec := mottuMat.MakeColVec(width*height)
+    if raw, ok := img.(RawImage); ok && width == Width && height == Height && len(raw) == Width*Height {
+        for i, v := range raw {
+            vec.SetElem(i, 0, float64(v)/255.0)
+        }
+        return vec
+    }
+
+    b := img.Bounds()
+    src_w, src_h := b.Dx(), b.Dy()
+    if src_w <= 0 || src_h <= 0 {
+        return vec
+    }
+    for ty := 0; ty < height; ty++ {
+        y0, y1 := span(ty, height, src_h)
+        for tx := 0; tx < width; tx++ {
+            x0, x1 := span(tx, width, src_w)
+            sum := 0.0
+            for y := y0; y < y1; y++ {
+                for x := x0; x < x1; x++ {
+                    sum += float64(color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y)
+                }
+            }
+            vec.SetElem(ty*width+tx, 0, sum/float64((y1-y0)*(x1-x0))/255.0)
+        }
+    }
+    return vec
+}
+
+// span returns the source pixels [lo, hi) that target pixel t of n covers
+// when src pixels are squeezed or stretched into n. It is never empty.
+func span(t, n, src int) (int, int) {
+    lo := t*src/n
+    hi := (t+1)*src/n
+    if hi <= lo {
+        hi = lo+1
+    }
+    return lo, hi
+}
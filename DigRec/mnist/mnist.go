@@ -6,8 +6,6 @@ It's purpose is to read the MNIST handwriting data.
 */
 
 import (
-    "compress/gzip"
-    "encoding/binary"
     "image"
     "image/color"
     "io"
@@ -42,121 +40,60 @@ func (img RawImage) At(x, y int) color.Color {
 }
 
 // ReadImageFile opens the named image file (training or test), parses it and
-// returns all images in order.
+// returns all images in order. The file may be gzipped or not.
 func ReadImageFile(name string) (rows, cols int, imgs []RawImage, err error) {
-    fmt.Println("ReadImageFile: Opening ", name);
     f, err := os.Open(name)
     if err != nil {
-        fmt.Println("ReadImageFile: Could not open file ", name)
         return 0, 0, nil, err
     }
     defer f.Close()
-    z, err := gzip.NewReader(f)
-    if err != nil {
-        fmt.Println("ReadImageFile: Issue with creating gzip reader")
-        return 0, 0, nil, err
-    }
-    return readImageFile(z)
+    return readImageFile(f)
 }
 
 func readImageFile(r io.Reader) (rows, cols int, imgs []RawImage, err error) {
-    var (
-        magic int32
-        n     int32
-        nrow  int32
-        ncol  int32
-    )
-    if err = binary.Read(r, binary.BigEndian, &magic); err != nil {
-        return 0, 0, nil, err
-    }
-    fmt.Printf("Read image magic num = %x\n", magic)
-    if magic != imageMagic {
-        return 0, 0, nil, os.ErrInvalid
-    }
-    if err = binary.Read(r, binary.BigEndian, &n); err != nil {
-        return 0, 0, nil, err
-    }
-    fmt.Printf("Read num images = %d\n", n)
-    if err = binary.Read(r, binary.BigEndian, &nrow); err != nil {
+    x, err := ReadIDX(r)
+    if err != nil {
         return 0, 0, nil, err
     }
-    fmt.Printf("Read num rows = %d\n", nrow) 
-    if err = binary.Read(r, binary.BigEndian, &ncol); err != nil {
-        return 0, 0, nil, err
+    if x.Magic() != imageMagic {
+        return 0, 0, nil, fmt.Errorf("mnist: images are %v with %d dimensions, want ubyte with 3: %w",
+                                     x.Type, len(x.Dims), os.ErrInvalid)
     }
-    fmt.Printf("Read num cols = %d\n", ncol)
+    n, nrow, ncol := x.Dims[0], x.Dims[1], x.Dims[2]
     imgs = make([]RawImage, n)
-    m := int(nrow * ncol)
-    for i := 0; i < int(n); i++ {
-        //fmt.Println("Trying to read image ", i)
-        imgs[i] = make(RawImage, m)
-        m_, err := io.ReadFull(r, imgs[i])
-        if err != nil {
-            return 0, 0, nil, err
-        }
-        if m_ != int(m) {
-            return 0, 0, nil, os.ErrInvalid
-        }
+    m := nrow * ncol
+    for i := 0; i < n; i++ {
+        imgs[i] = RawImage(x.Data[i*m:(i+1)*m:(i+1)*m])
     }
-    return int(nrow), int(ncol), imgs, nil
+    return nrow, ncol, imgs, nil
 }
 
 // Label is a digit label in 0 to 9
 type Label uint8
 
 //ReadLabelFile opens the named label file (training or test), parses it and
-// returns all labels in order.
+// returns all labels in order. The file may be gzipped or not.
 func ReadLabelFile(name string) (labels []Label, err error) {
-    fmt.Println("Reading file ", name)
     f, err := os.Open(name)
     if err != nil {
         return nil, err
     }
     defer f.Close()
-    z, err := gzip.NewReader(f)
-    if err != nil {
-        return nil, err
-    }
-    return readLabelFile(z)
+    return readLabelFile(f)
 }
 
 func readLabelFile(r io.Reader) (labels []Label, err error) {
-    var (
-        magic int32
-        n int32
-    )
-    if err = binary.Read(r, binary.BigEndian, &magic); err != nil {
+    x, err := ReadIDX(r)
+    if err != nil {
         return nil, err
     }
-    fmt.Printf("Read magic number on label file = %x\n", magic)
-    if magic != labelMagic {
-        return nil, os.ErrInvalid
+    if x.Magic() != labelMagic {
+        return nil, fmt.Errorf("mnist: labels are %v with %d dimensions, want ubyte with 1: %w",
+                               x.Type, len(x.Dims), os.ErrInvalid)
     }
-    if err = binary.Read(r, binary.BigEndian, &n); err != nil {
-        return nil, err
-    }
-    fmt.Printf("Read num labels = %d\n", n)
-    labels = make([]Label, n)
-    for i := 0; i < int(n); i++ {
-        var l Label
-        if err := binary.Read(r, binary.BigEndian, &l); err != nil {
-            return nil, err
-        }
-        labels[i] = l
+    labels = make([]Label, x.Dims[0])
+    for i := range labels {
+        labels[i] = Label(x.Data[i])
     }
     return labels, nil
 }
-
-
-
-
-/////////////// FOR DEBUG ONLY
-
-
-
-
-
-
-
-
-
@@ -0,0 +1,198 @@
+package mnist
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    _ "image/gif"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "math"
+    "os"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// The MNIST digits were scaled to fit a box this big, keeping their aspect
+// ratio, and then centered by center of mass in the Width x Height frame
+const digitBox = 20
+
+// Pixels below this ink level (out of 1) don't count when finding the
+// bounding box of the digit, so noise and paper texture get cropped away
+const inkThreshold = 0.2
+
+// MaxPixels is the largest picture Preprocess takes. It holds every pixel
+// as a float64, so this bounds it to 128MB.
+const MaxPixels = 4096*4096
+
+// ErrImageTooLarge is returned for pictures of more than MaxPixels pixels
+var ErrImageTooLarge = errors.New("mnist: image too large")
+
+// DecodeImage reads a PNG, JPEG or GIF image
+func DecodeImage(r io.Reader) (image.Image, error) {
+    img, _, err := image.Decode(r)
+    return img, err
+}
+
+// LoadImage reads the named PNG, JPEG or GIF file
+func LoadImage(name string) (image.Image, error) {
+    f, err := os.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return DecodeImage(f)
+}
+
+// PreprocessFile reads an image file and preprocesses it into a col vec
+// ready for FeedForward
+func PreprocessFile(name string) (*mottuMat.MottuMat, error) {
+    img, err := LoadImage(name)
+    if err != nil {
+        return nil, err
+    }
+    raw, err := Preprocess(img)
+    if err != nil {
+        return nil, err
+    }
+    return ImageVec(raw, Width, Height), nil
+}
+
+// Preprocess turns a picture of a single digit into an image laid out the
+// way the MNIST ones are:
+//
+//  1. it is converted to grayscale, with transparent pixels taken as paper
+//  2. it is inverted when the background is lighter than the digit, going by
+//     the border, so the digit is bright on black
+//  3. it is cropped to the bounding box of the digit
+//  4. it is scaled, keeping its aspect ratio, to fit a 20x20 box
+//  5. it is placed in a 28x28 frame with its center of mass in the middle
+//
+// A picture with nothing on it gives a blank image. Pictures of more than
+// MaxPixels pixels are refused with ErrImageTooLarge.
+func Preprocess(img image.Image) (RawImage, error) {
+    b := img.Bounds()
+    if b.Dx() > MaxPixels || b.Dy() > MaxPixels || b.Dx()*b.Dy() > MaxPixels {
+        return nil, fmt.Errorf("%w: %dx%d, at most %d pixels", ErrImageTooLarge, b.Dx(), b.Dy(), MaxPixels)
+    }
+    ink := gray_levels(img)
+    retval := make(RawImage, Width*Height)
+    h := len(ink)
+    if h == 0 || len(ink[0]) == 0 {
+        return retval, nil
+    }
+    w := len(ink[0])
+
+    // 2. Look at the border to tell the background from the digit
+    border, n := 0.0, 0
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            if y == 0 || y == h-1 || x == 0 || x == w-1 {
+                border += ink[y][x]
+                n++
+            }
+        }
+    }
+    if border/float64(n) > 0.5 {
+        for y := range ink {
+            for x := range ink[y] {
+                ink[y][x] = 1-ink[y][x]
+            }
+        }
+    }
+
+    // 3. Crop
+    min_x, min_y, max_x, max_y := w, h, -1, -1
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            if ink[y][x] > inkThreshold {
+                min_x, max_x = min(min_x, x), max(max_x, x)
+                min_y, max_y = min(min_y, y), max(max_y, y)
+            }
+        }
+    }
+    if max_x < 0 {
+        return retval, nil
+    }
+    crop := make([][]float64, max_y-min_y+1)
+    for y := range crop {
+        crop[y] = ink[min_y+y][min_x:max_x+1]
+    }
+
+    // 4. Scale the longer side to digitBox
+    crop_w, crop_h := len(crop[0]), len(crop)
+    scale := float64(digitBox)/float64(max(crop_w, crop_h))
+    box_w := max(1, int(math.Round(float64(crop_w)*scale)))
+    box_h := max(1, int(math.Round(float64(crop_h)*scale)))
+    box := resample(crop, box_w, box_h)
+
+    // 5. Center by center of mass
+    mass, cx, cy := 0.0, 0.0, 0.0
+    for y := range box {
+        for x, v := range box[y] {
+            mass += v
+            cx += v*float64(x)
+            cy += v*float64(y)
+        }
+    }
+    off_x := int(math.Round(float64(Width-1)/2 - cx/mass))
+    off_y := int(math.Round(float64(Height-1)/2 - cy/mass))
+    for y := range box {
+        for x, v := range box[y] {
+            fx, fy := x+off_x, y+off_y
+            if fx < 0 || fx >= Width || fy < 0 || fy >= Height {
+                continue
+            }
+            retval[fy*Width+fx] = uint8(math.Round(255*math.Min(1, math.Max(0, v))))
+        }
+    }
+    return retval, nil
+}
+
+// gray_levels returns the darkness of every pixel of img in [0, 1], row by
+// row. Transparent pixels are composited onto white.
+func gray_levels(img image.Image) [][]float64 {
+    b := img.Bounds()
+    levels := make([][]float64, b.Dy())
+    for y := range levels {
+        levels[y] = make([]float64, b.Dx())
+        for x := range levels[y] {
+            r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+            // On white, a premultiplied channel c becomes c + (0xffff - a)
+            white := 0xffff - a
+            gray := color.Gray16Model.Convert(color.RGBA64{
+                uint16(r + white), uint16(g + white), uint16(bl + white), 0xffff,
+            }).(color.Gray16).Y
+            levels[y][x] = 1 - float64(gray)/0xffff
+        }
+    }
+    return levels
+}
+
+// resample scales src to w x h. Every target pixel is the average of the
+// part of src it covers, weighting partly covered pixels by how much of them
+// it covers, so it works for shrinking and stretching alike.
+func resample(src [][]float64, w, h int) [][]float64 {
+    src_h, src_w := len(src), len(src[0])
+    sx := float64(src_w)/float64(w)
+    sy := float64(src_h)/float64(h)
+    dst := make([][]float64, h)
+    for ty := 0; ty < h; ty++ {
+        dst[ty] = make([]float64, w)
+        y0, y1 := float64(ty)*sy, float64(ty+1)*sy
+        for tx := 0; tx < w; tx++ {
+            x0, x1 := float64(tx)*sx, float64(tx+1)*sx
+            sum := 0.0
+            for y := int(y0); y < src_h && float64(y) < y1; y++ {
+                wy := math.Min(y1, float64(y+1)) - math.Max(y0, float64(y))
+                for x := int(x0); x < src_w && float64(x) < x1; x++ {
+                    wx := math.Min(x1, float64(x+1)) - math.Max(x0, float64(x))
+                    sum += wx*wy*src[y][x]
+                }
+            }
+            dst[ty][tx] = sum/(sx*sy)
+        }
+    }
+    return dst
+}
@@ -0,0 +1,140 @@
+package mnist
+
+import (
+    "errors"
+    "image"
+    "image/color"
+    "math"
+    "testing"
+)
+
+// picture returns a w x h picture of the given background with the
+// rectangle r filled with ink
+func picture(w, h int, background, ink color.Color, r image.Rectangle) image.Image {
+    img := image.NewNRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            if (image.Point{x, y}).In(r) {
+                img.Set(x, y, ink)
+            } else {
+                img.Set(x, y, background)
+            }
+        }
+    }
+    return img
+}
+
+// ink_box returns the bounding box of the nonzero pixels of raw
+func ink_box(raw RawImage) image.Rectangle {
+    box := image.Rectangle{}
+    for i, v := range raw {
+        if v != 0 {
+            box = box.Union(image.Rect(i%Width, i/Width, i%Width+1, i/Width+1))
+        }
+    }
+    return box
+}
+
+func must_preprocess(t *testing.T, img image.Image) RawImage {
+    t.Helper()
+    raw, err := Preprocess(img)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(raw) != Width*Height {
+        t.Fatalf("preprocessed to %d pixels, want %d", len(raw), Width*Height)
+    }
+    return raw
+}
+
+// The digit is scaled, keeping its aspect ratio, to fit 20x20 in a 28x28
+// frame
+func TestPreprocessBox(t *testing.T) {
+    tests := []struct {
+        ink image.Rectangle
+        w, h int
+    }{
+        {image.Rect(10, 10, 50, 50), 20, 20},
+        {image.Rect(30, 5, 40, 85), 3, 20},
+        {image.Rect(2, 40, 62, 55), 20, 5},
+        {image.Rect(45, 45, 50, 50), 20, 20}, // small digits are scaled up
+    }
+    for _, test := range tests {
+        raw := must_preprocess(t, picture(100, 90, color.White, color.Black, test.ink))
+        if box := ink_box(raw); box.Dx() != test.w || box.Dy() != test.h {
+            t.Errorf("ink %v: digit is %dx%d, want %dx%d", test.ink, box.Dx(), box.Dy(), test.w, test.h)
+        }
+    }
+}
+
+// The center of mass lands in the middle of the frame, wherever the digit
+// was in the picture
+func TestPreprocessCentersByMass(t *testing.T) {
+    // An L: the mass is off the center of its bounding box
+    img := image.NewGray(image.Rect(0, 0, 60, 60))
+    for y := 0; y < 60; y++ {
+        for x := 0; x < 60; x++ {
+            img.SetGray(x, y, color.Gray{255})
+            if x >= 5 && x < 25 && y >= 5 && y < 45 && (x < 10 || y >= 38) {
+                img.SetGray(x, y, color.Gray{0})
+            }
+        }
+    }
+    raw := must_preprocess(t, img)
+    mass, cx, cy := 0.0, 0.0, 0.0
+    for i, v := range raw {
+        mass += float64(v)
+        cx += float64(v)*float64(i%Width)
+        cy += float64(v)*float64(i/Width)
+    }
+    cx, cy = cx/mass, cy/mass
+    if math.Abs(cx - 13.5) > 0.5 || math.Abs(cy - 13.5) > 0.5 {
+        t.Errorf("center of mass at (%.2f, %.2f), want (13.5, 13.5)", cx, cy)
+    }
+    box := ink_box(raw)
+    if box.Min.X+box.Max.X == Width && box.Min.Y+box.Max.Y == Height {
+        t.Errorf("the L's bounding box %v is centered, the mass isn't", box)
+    }
+}
+
+// Dark on light is inverted to MNIST's bright on dark, and transparent
+// pixels are paper
+func TestPreprocessInverts(t *testing.T) {
+    r := image.Rect(20, 10, 30, 50)
+    want := must_preprocess(t, picture(50, 60, color.White, color.Black, r))
+    if want[14*Width+14] != 255 || want[0] != 0 {
+        t.Fatalf("the digit is %d and the background %d, want 255 and 0", want[14*Width+14], want[0])
+    }
+    for name, img := range map[string]image.Image{
+        "bright on dark": picture(50, 60, color.Black, color.White, r),
+        "dark on transparent": picture(50, 60, color.Transparent, color.Black, r),
+    } {
+        got := must_preprocess(t, img)
+        for i := range want {
+            if got[i] != want[i] {
+                t.Errorf("%s: pixel %d is %d, want %d", name, i, got[i], want[i])
+                break
+            }
+        }
+    }
+    if blank := must_preprocess(t, picture(30, 30, color.White, color.White, image.Rectangle{})); ink_box(blank) != (image.Rectangle{}) {
+        t.Errorf("a blank picture has ink at %v", ink_box(blank))
+    }
+}
+
+// huge_image claims to be large without holding any pixels
+type huge_image struct {
+    w, h int
+}
+
+func (h huge_image) ColorModel() color.Model { return color.GrayModel }
+func (h huge_image) Bounds() image.Rectangle { return image.Rect(0, 0, h.w, h.h) }
+func (h huge_image) At(x, y int) color.Color { return color.White }
+
+func TestPreprocessTooLarge(t *testing.T) {
+    for _, img := range []huge_image{{MaxPixels+1, 1}, {4097, 4096}, {1 << 40, 1 << 40}} {
+        if _, err := Preprocess(img); !errors.Is(err, ErrImageTooLarge) {
+            t.Errorf("%dx%d: got %v, want ErrImageTooLarge", img.w, img.h, err)
+        }
+    }
+}
@@ -3,14 +3,25 @@ package mnist
     Code from: https://github.com/petar/GoMNIST/blob/master/util.go
 */
 import (
+    "os"
     "path"
     "math/rand"
+    "strings"
     "NeuralNetworks/DigRec/mottuMat"
 //    "time"
 )
 
 const NUM_TYPES_OF_DIGITS int = 10
 
+// Names of the files in the MNIST distribution. Load and FindFile also
+// accept them unpacked, without the .gz.
+const (
+    TrainImagesFile = "train-images-idx3-ubyte.gz"
+    TrainLabelsFile = "train-labels-idx1-ubyte.gz"
+    TestImagesFile = "t10k-images-idx3-ubyte.gz"
+    TestLabelsFile = "t10k-labels-idx1-ubyte.gz"
+)
+
 // Set represents a data set of image-label pairs held in memory
 type Set struct {
     NRow int
@@ -69,6 +80,18 @@ func (s *Set) Get(i int) (*mottuMat.MottuMat, *mottuMat.MottuMat) {
     return s.Images[i], s.ExpOut[i]
 }
 
+// Label returns the digit the ith image is labelled with, the row of its
+// largest expected output
+func (s *Set) Label(i int) int {
+    label := 0
+    for j := 1; j < s.ExpOut[i].Rows(); j++ {
+        if s.ExpOut[i].GetElem(j, 0) > s.ExpOut[i].GetElem(label, 0) {
+            label = j
+        }
+    }
+    return label
+}
+
 
 // Sweeper is an iterator over the points in a data set
 type Sweeper struct {
@@ -118,18 +141,41 @@ func (s *Set) Sweep() *Sweeper {
     return sw
 }
 
+// SweepWith is Sweep shuffling with r rather than a fixed seed
+func (s *Set) SweepWith(r *rand.Rand) *Sweeper {
+    sw := s.Sweep()
+    sw.r = r
+    return sw
+}
+
+
+// FindFile returns the path of the named file in dir. When the name ends in
+// .gz and there is no such file but there is one without the .gz, such as
+// an unpacked copy of the distribution, that one is returned instead.
+func FindFile(dir, name string) string {
+    p := path.Join(dir, name)
+    if !strings.HasSuffix(p, ".gz") || exists(p) {
+        return p
+    }
+    if unpacked := strings.TrimSuffix(p, ".gz"); exists(unpacked) {
+        return unpacked
+    }
+    return p
+}
+
+func exists(name string) bool {
+    _, err := os.Stat(name)
+    return err == nil
+}
 
 // Load reads both the training and the testing MNIST data sets, given
-// a local directory dir, containing the MNIST disribution files
+// a local directory dir, containing the MNIST disribution files, gzipped
+// or not
 func Load(dir string) (train, test *Set, err error) {
-    tr_im_str := "train-images-idx3-ubyte.gz"
-    tr_lab_str := "train-labels-idx1-ubyte.gz"
-    t10k_im_str := "t10k-images-idx3-ubyte.gz"
-    t10k_lab_str := "t10k-labels-idx1-ubyte.gz"
-    if train, err = ReadSet(path.Join(dir, tr_im_str), path.Join(dir, tr_lab_str)); err != nil {
+    if train, err = ReadSet(FindFile(dir, TrainImagesFile), FindFile(dir, TrainLabelsFile)); err != nil {
         return nil, nil, err
     }
-    if test, err = ReadSet(path.Join(dir, t10k_im_str), path.Join(dir, t10k_lab_str)); err != nil {
+    if test, err = ReadSet(FindFile(dir, TestImagesFile), FindFile(dir, TestLabelsFile)); err != nil {
         return nil, nil, err
     }
     return
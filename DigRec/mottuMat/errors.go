@@ -0,0 +1,149 @@
+package mottuMat
+
+import "fmt"
+
+// Shape is the dimensions of a matrix
+type Shape struct {
+    Rows int
+    Cols int
+}
+
+func (s Shape) String() string {
+    return fmt.Sprintf("%dx%d", s.Rows, s.Cols)
+}
+
+// Shape returns the dimensions of recv
+func (recv *MottuMat) Shape() Shape {
+    return Shape{recv.numRows, recv.numCols}
+}
+
+// ShapeError reports an operation given matrices whose dimensions don't
+// work together. The panicking operations panic with a *ShapeError, the
+// Try variants return it.
+type ShapeError struct {
+    Op string
+    A Shape
+    B Shape
+}
+
+func (e *ShapeError) Error() string {
+    return fmt.Sprintf("mottuMat: %s: dimensions mismatch: %v and %v", e.Op, e.A, e.B)
+}
+
+func shapeError(op string, a, b *MottuMat) *ShapeError {
+    return &ShapeError{Op: op, A: a.Shape(), B: b.Shape()}
+}
+
+// ==================== shape checks ===============
+
+func checkSame(op string, a, b *MottuMat) error {
+    if a.numRows != b.numRows || a.numCols != b.numCols {
+        return shapeError(op, a, b)
+    }
+    return nil
+}
+
+func checkMul(a, b *MottuMat) error {
+    if a.numCols != b.numRows {
+        return shapeError("Mul", a, b)
+    }
+    return nil
+}
+
+func checkMulT(a, b *MottuMat) error {
+    if a.numCols != b.numCols {
+        return shapeError("MulT", a, b)
+    }
+    return nil
+}
+
+func checkTMul(a, b *MottuMat) error {
+    if a.numRows != b.numRows {
+        return shapeError("TMul", a, b)
+    }
+    return nil
+}
+
+func checkBroadMul(a, b *MottuMat) error {
+    if a.numCols != 1 || b.numCols != 1 {
+        return shapeError("BroadMul", a, b)
+    }
+    return nil
+}
+
+// The error names whichever of x and b doesn't fit A
+func checkLinMatExp(A, x, b *MottuMat) error {
+    if A.numCols != x.numRows || x.numCols != 1 {
+        return shapeError("EvalLinMatExp", A, x)
+    }
+    if A.numRows != b.numRows || b.numCols != 1 {
+        return shapeError("EvalLinMatExp", A, b)
+    }
+    return nil
+}
+
+// ==================== error returning API ===============
+
+// TryEvalLinMatExp is EvalLinMatExp returning a *ShapeError instead of panicking
+func TryEvalLinMatExp(A, x, b *MottuMat) (*MottuMat, error) {
+    if err := checkLinMatExp(A, x, b); err != nil {
+        return nil, err
+    }
+    return EvalLinMatExp(A, x, b), nil
+}
+
+// TryAdd is Add returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryAdd(m *MottuMat) (*MottuMat, error) {
+    if err := checkSame("Add", recv, m); err != nil {
+        return nil, err
+    }
+    return recv.Add(m), nil
+}
+
+// TrySub is Sub returning a *ShapeError instead of panicking
+func (recv *MottuMat) TrySub(m *MottuMat) (*MottuMat, error) {
+    if err := checkSame("Sub", recv, m); err != nil {
+        return nil, err
+    }
+    return recv.Sub(m), nil
+}
+
+// TryMul is Mul returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryMul(B *MottuMat) (*MottuMat, error) {
+    if err := checkMul(recv, B); err != nil {
+        return nil, err
+    }
+    return recv.Mul(B), nil
+}
+
+// TryMulT is MulT returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryMulT(B *MottuMat) (*MottuMat, error) {
+    if err := checkMulT(recv, B); err != nil {
+        return nil, err
+    }
+    return recv.MulT(B), nil
+}
+
+// TryTMul is TMul returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryTMul(B *MottuMat) (*MottuMat, error) {
+    if err := checkTMul(recv, B); err != nil {
+        return nil, err
+    }
+    return recv.TMul(B), nil
+}
+
+// TryHadMul is HadMul returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryHadMul(m *MottuMat) (*MottuMat, error) {
+    if err := checkSame("HadMul", recv, m); err != nil {
+        return nil, err
+    }
+    return recv.HadMul(m), nil
+}
+
+// TryBroadMul is BroadMul returning a *ShapeError instead of panicking
+func (recv *MottuMat) TryBroadMul(m *MottuMat) (*MottuMat, error) {
+    if err := checkBroadMul(recv, m); err != nil {
+        return nil, err
+    }
+    return recv.BroadMul(m), nil
+}
@@ -0,0 +1,107 @@
+package mottuMat
+
+import (
+    "errors"
+    "math/rand"
+    "testing"
+)
+
+type try_case struct {
+    op string
+    try func() (*MottuMat, error)
+    must func() *MottuMat // the panicking version
+    a, b Shape // the shapes the error should report
+}
+
+// try_cases returns every Try function applied to matrices that don't fit,
+// along with the shapes the error names
+func try_cases(r *rand.Rand) []try_case {
+    m23, m32, m22 := random_mat(2, 3, r), random_mat(3, 2, r), random_mat(2, 2, r)
+    v2, v3 := random_mat(2, 1, r), random_mat(3, 1, r)
+    s23, s32, s22 := Shape{2, 3}, Shape{3, 2}, Shape{2, 2}
+    s21, s31 := Shape{2, 1}, Shape{3, 1}
+    return []try_case{
+        {"Add", func() (*MottuMat, error) { return m23.TryAdd(m32) }, func() *MottuMat { return m23.Add(m32) }, s23, s32},
+        {"Sub", func() (*MottuMat, error) { return m22.TrySub(m23) }, func() *MottuMat { return m22.Sub(m23) }, s22, s23},
+        {"Mul", func() (*MottuMat, error) { return m23.TryMul(m23) }, func() *MottuMat { return m23.Mul(m23) }, s23, s23},
+        {"MulT", func() (*MottuMat, error) { return m23.TryMulT(m32) }, func() *MottuMat { return m23.MulT(m32) }, s23, s32},
+        {"TMul", func() (*MottuMat, error) { return m23.TryTMul(m32) }, func() *MottuMat { return m23.TMul(m32) }, s23, s32},
+        {"HadMul", func() (*MottuMat, error) { return m32.TryHadMul(m23) }, func() *MottuMat { return m32.HadMul(m23) }, s32, s23},
+        {"BroadMul", func() (*MottuMat, error) { return v2.TryBroadMul(m22) }, func() *MottuMat { return v2.BroadMul(m22) }, s21, s22},
+        {"EvalLinMatExp", func() (*MottuMat, error) { return TryEvalLinMatExp(m23, v2, v2) },
+         func() *MottuMat { return EvalLinMatExp(m23, v2, v2) }, s23, s21},
+        {"EvalLinMatExp", func() (*MottuMat, error) { return TryEvalLinMatExp(m23, v3, v3) },
+         func() *MottuMat { return EvalLinMatExp(m23, v3, v3) }, s23, s31},
+    }
+}
+
+// The Try functions return a *ShapeError naming the operation and both
+// shapes rather than panicking, and the other functions panic with the same
+// error
+func TestTryShapeErrors(t *testing.T) {
+    for _, c := range try_cases(rand.New(rand.NewSource(1))) {
+        var got *MottuMat
+        var err error
+        func() {
+            defer func() {
+                if p := recover(); p != nil {
+                    t.Errorf("Try%s panicked: %v", c.op, p)
+                }
+            }()
+            got, err = c.try()
+        }()
+        var se *ShapeError
+        if !errors.As(err, &se) || got != nil {
+            t.Errorf("Try%s returned %v, %v, want a *ShapeError", c.op, got, err)
+            continue
+        }
+        want := ShapeError{Op: c.op, A: c.a, B: c.b}
+        if *se != want {
+            t.Errorf("Try%s: error %+v, want %+v", c.op, *se, want)
+        }
+
+        func() {
+            defer func() {
+                if p, ok := recover().(*ShapeError); !ok || *p != want {
+                    t.Errorf("%s panicked with %v, want %+v", c.op, p, want)
+                }
+            }()
+            c.must()
+        }()
+    }
+}
+
+// With matching shapes the Try functions give what the others do
+func TestTrySuccess(t *testing.T) {
+    r := rand.New(rand.NewSource(2))
+    a, b, c := random_mat(2, 3, r), random_mat(2, 3, r), random_mat(3, 4, r)
+    x, v := random_mat(3, 1, r), random_mat(2, 1, r)
+    for _, test := range []struct {
+        op string
+        try func() (*MottuMat, error)
+        want *MottuMat
+    }{
+        {"Add", func() (*MottuMat, error) { return a.TryAdd(b) }, a.Add(b)},
+        {"Sub", func() (*MottuMat, error) { return a.TrySub(b) }, a.Sub(b)},
+        {"Mul", func() (*MottuMat, error) { return a.TryMul(c) }, a.Mul(c)},
+        {"MulT", func() (*MottuMat, error) { return a.TryMulT(b) }, a.MulT(b)},
+        {"TMul", func() (*MottuMat, error) { return a.TryTMul(b) }, a.TMul(b)},
+        {"HadMul", func() (*MottuMat, error) { return a.TryHadMul(b) }, a.HadMul(b)},
+        {"BroadMul", func() (*MottuMat, error) { return v.TryBroadMul(x) }, v.BroadMul(x)},
+        {"EvalLinMatExp", func() (*MottuMat, error) { return TryEvalLinMatExp(a, x, v) }, EvalLinMatExp(a, x, v)},
+    } {
+        got, err := test.try()
+        if err != nil {
+            t.Errorf("Try%s: %v", test.op, err)
+            continue
+        }
+        check_close(t, test.op, got, test.want)
+    }
+}
+
+func TestShapeErrorMessage(t *testing.T) {
+    err := &ShapeError{Op: "Mul", A: Shape{2, 3}, B: Shape{4, 5}}
+    if want := "mottuMat: Mul: dimensions mismatch: 2x3 and 4x5"; err.Error() != want {
+        t.Errorf("message %q, want %q", err.Error(), want)
+    }
+}
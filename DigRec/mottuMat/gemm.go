@@ -0,0 +1,94 @@
+package mottuMat
+
+// Kernels behind Mul, MulT, TMul and EvalLinMatExp.
+//
+// Everything works on contiguous row slices: the inner loops never stride
+// through memory, which lets the compiler drop bounds checks and keeps the
+// CPU's prefetcher and vector units busy. The products are also tiled so the
+// rows being reused stay in cache while a tile is processed.
+
+const (
+    blockRows  = 64  // rows of the result per tile
+    blockInner = 128 // length of the shared dimension per tile
+    blockCols  = 256 // cols of the result per tile
+)
+
+// dot returns sum x[i]*y[i]. len(y) must be at least len(x).
+func dot(x, y []float64) float64 {
+    y = y[:len(x)]
+    var s0, s1, s2, s3 float64
+    i := 0
+    for ; i+4 <= len(x); i += 4 {
+        s0 += x[i]*y[i]
+        s1 += x[i+1]*y[i+1]
+        s2 += x[i+2]*y[i+2]
+        s3 += x[i+3]*y[i+3]
+    }
+    for ; i < len(x); i++ {
+        s0 += x[i]*y[i]
+    }
+    return (s0+s1)+(s2+s3)
+}
+
+// axpy does y += alpha*x. len(y) must be at least len(x).
+func axpy(alpha float64, x, y []float64) {
+    y = y[:len(x)]
+    i := 0
+    for ; i+4 <= len(x); i += 4 {
+        y[i] += alpha*x[i]
+        y[i+1] += alpha*x[i+1]
+        y[i+2] += alpha*x[i+2]
+        y[i+3] += alpha*x[i+3]
+    }
+    for ; i < len(x); i++ {
+        y[i] += alpha*x[i]
+    }
+}
+
+// gemm computes c = a*b where a is nxm and b is mxq
+func gemm(a, b, c []float64, n, m, q int) {
+    for ii := 0; ii < n; ii += blockRows {
+        i_end := min(ii+blockRows, n)
+        for kk := 0; kk < m; kk += blockInner {
+            k_end := min(kk+blockInner, m)
+            for jj := 0; jj < q; jj += blockCols {
+                j_end := min(jj+blockCols, q)
+                for i := ii; i < i_end; i++ {
+                    c_row := c[i*q+jj:i*q+j_end]
+                    a_row := a[i*m:(i+1)*m]
+                    for k := kk; k < k_end; k++ {
+                        axpy(a_row[k], b[k*q+jj:k*q+j_end], c_row)
+                    }
+                }
+            }
+        }
+    }
+}
+
+// gemmNT computes c = a*b^T where a is nxm and b is qxm
+func gemmNT(a, b, c []float64, n, m, q int) {
+    for jj := 0; jj < q; jj += blockRows {
+        j_end := min(jj+blockRows, q)
+        for i := 0; i < n; i++ {
+            a_row := a[i*m:(i+1)*m]
+            c_row := c[i*q:(i+1)*q]
+            for j := jj; j < j_end; j++ {
+                c_row[j] = dot(a_row, b[j*m:(j+1)*m])
+            }
+        }
+    }
+}
+
+// gemmTN computes c = a^T*b where a is mxn and b is mxq
+func gemmTN(a, b, c []float64, n, m, q int) {
+    for ii := 0; ii < n; ii += blockRows {
+        i_end := min(ii+blockRows, n)
+        for k := 0; k < m; k++ {
+            a_row := a[k*n:(k+1)*n]
+            b_row := b[k*q:(k+1)*q]
+            for i := ii; i < i_end; i++ {
+                axpy(a_row[i], b_row, c[i*q:(i+1)*q])
+            }
+        }
+    }
+}
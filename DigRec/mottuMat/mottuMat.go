@@ -1,6 +1,7 @@
 package mottuMat
 
 import (
+    "math"
     "math/rand"
 //    "time"
     "fmt"
@@ -22,6 +23,25 @@ func MakeMat(rows, cols int) *MottuMat {
 }
 
 
+// HStack places column vectors side by side in a single matrix, so
+// result[i][j] = cols[j][i]
+func HStack(cols []*MottuMat) *MottuMat {
+    if len(cols) == 0 {
+        return MakeMat(0, 0)
+    }
+    n := cols[0].numRows
+    result := MakeMat(n, len(cols))
+    for j, c := range cols {
+        if c.numRows != n || c.numCols != 1 {
+            panic(shapeError("HStack", cols[0], c))
+        }
+        for i := 0; i < n; i++ {
+            result.data[i*result.numCols+j] = c.data[i]
+        }
+    }
+    return result
+}
+
 // MakeRowVec
 func MakeRowVec(cols int) *MottuMat {
     return MakeMat(1, cols)
@@ -37,19 +57,15 @@ func MakeColVec(rows int) *MottuMat {
 // b is a col vec (nx1)
 //
 func EvalLinMatExp(A, x, b *MottuMat) *MottuMat {
-    if A.numCols != x.numRows || A.numRows != b.numRows || x.numCols != 1 || b.numCols != 1 {
-        panic("Dimensions mismatch")
+    if err := checkLinMatExp(A, x, b); err != nil {
+        panic(err)
     }
     // nxm mxq
     n := A.numRows
     m := A.numCols
     result := MakeMat(n, 1)
     for i := 0; i < n; i++ {
-        acc := b.data[i];
-        for k := 0; k < m; k++ {
-            acc += A.data[i*m+k]*x.data[k]
-        }
-        result.data[i] = acc 
+        result.data[i] = b.data[i] + dot(A.data[i*m:(i+1)*m], x.data)
     }
     return result
 }
@@ -82,8 +98,8 @@ func (recv *MottuMat) Cols() int {
 
 // Add
 func (recv *MottuMat) Add(m *MottuMat) *MottuMat {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimensions mismatch")
+    if err := checkSame("Add", recv, m); err != nil {
+        panic(err)
     }
     result := MakeMat(recv.numRows, recv.numCols)
     for i := 0; i < len(result.data); i++ {
@@ -93,17 +109,43 @@ func (recv *MottuMat) Add(m *MottuMat) *MottuMat {
 }
 
 func (recv *MottuMat) AddEq(m *MottuMat) {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimensions mismatch")
+    if err := checkSame("AddEq", recv, m); err != nil {
+        panic(err)
     }
     for i := 0; i < len(recv.data); i++ {
         recv.data[i] += m.data[i]
     }
 }
+// AddColEq adds the col vec b to every column of recv
+func (recv *MottuMat) AddColEq(b *MottuMat) {
+    if b.numCols != 1 || b.numRows != recv.numRows {
+        panic(shapeError("AddColEq", recv, b))
+    }
+    for i := 0; i < recv.numRows; i++ {
+        row := recv.data[i*recv.numCols:(i+1)*recv.numCols]
+        for j := range row {
+            row[j] += b.data[i]
+        }
+    }
+}
+
+// SumCols returns the col vec holding the sum of each row of recv
+func (recv *MottuMat) SumCols() *MottuMat {
+    result := MakeColVec(recv.numRows)
+    for i := 0; i < recv.numRows; i++ {
+        acc := 0.0
+        for _, v := range recv.data[i*recv.numCols:(i+1)*recv.numCols] {
+            acc += v
+        }
+        result.data[i] = acc
+    }
+    return result
+}
+
 // Sub
 func (recv *MottuMat) Sub(m *MottuMat) *MottuMat {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimensions mismatch")
+    if err := checkSame("Sub", recv, m); err != nil {
+        panic(err)
     }
     result := MakeMat(recv.numRows, recv.numCols)
     for i := 0; i < len(result.data); i++ {
@@ -113,8 +155,8 @@ func (recv *MottuMat) Sub(m *MottuMat) *MottuMat {
 }
 
 func (recv *MottuMat) SubEq(m *MottuMat) {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimensions mismatch")
+    if err := checkSame("SubEq", recv, m); err != nil {
+        panic(err)
     }
     for i := 0; i < len(recv.data); i++ {
         recv.data[i] -= m.data[i]
@@ -124,23 +166,43 @@ func (recv *MottuMat) SubEq(m *MottuMat) {
 
 // Mul
 func (recv *MottuMat) Mul(B *MottuMat) *MottuMat {
-    if recv.numCols != B.numRows {
-        panic("Incompatible dimensions")
+    if err := checkMul(recv, B); err != nil {
+        panic(err)
     }
     // nxm mxq
     n := recv.numRows
     m := recv.numCols
     q := B.numCols
     result := MakeMat(n, q)
-    for i := 0; i < n; i++ {
-        for j := 0; j < q; j++ {
-            acc := 0.0;
-            for k := 0; k < m; k++ {
-                acc += recv.data[i*m+k]*B.data[k*q+j]
-            }
-            result.data[i*q+j] = acc
-        }
+    gemm(recv.data, B.data, result.data, n, m, q)
+    return result
+}
+
+// MulT returns recv * B^T without building the transpose of B
+func (recv *MottuMat) MulT(B *MottuMat) *MottuMat {
+    if err := checkMulT(recv, B); err != nil {
+        panic(err)
+    }
+    // nxm (qxm)^T
+    n := recv.numRows
+    m := recv.numCols
+    q := B.numRows
+    result := MakeMat(n, q)
+    gemmNT(recv.data, B.data, result.data, n, m, q)
+    return result
+}
+
+// TMul returns recv^T * B without building the transpose of recv
+func (recv *MottuMat) TMul(B *MottuMat) *MottuMat {
+    if err := checkTMul(recv, B); err != nil {
+        panic(err)
     }
+    // (mxn)^T mxq
+    n := recv.numCols
+    m := recv.numRows
+    q := B.numCols
+    result := MakeMat(n, q)
+    gemmTN(recv.data, B.data, result.data, n, m, q)
     return result
 }
 
@@ -185,8 +247,8 @@ func (recv *MottuMat) SetElem(i, j int, val float64) {
 }
 // HadMul
 func (recv *MottuMat)      HadMul(m *MottuMat) *MottuMat {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimension mismatch")
+    if err := checkSame("HadMul", recv, m); err != nil {
+        panic(err)
     }
     result := MakeMat(recv.numRows, recv.numCols)
     for i := 0; i < len(result.data); i++ {
@@ -196,8 +258,8 @@ func (recv *MottuMat)      HadMul(m *MottuMat) *MottuMat {
 }
 
 func (recv *MottuMat) HadMulEq(m *MottuMat) {
-    if recv.numRows != m.numRows || recv.numCols != m.numCols {
-        panic("Dimension mismatch")
+    if err := checkSame("HadMulEq", recv, m); err != nil {
+        panic(err)
     }
  
     for i := 0; i < len(recv.data); i++ {
@@ -222,8 +284,8 @@ func (recv *MottuMat) Transpose() *MottuMat {
 // Creates a matrix from two col vectors.
 // The result[i][j] = recv[i] * m[j]
 func (recv *MottuMat) BroadMul(m *MottuMat) *MottuMat {
-    if !(recv.numCols == 1 && m.numCols == 1) {
-        panic("Can't broadcast multiply.")
+    if err := checkBroadMul(recv, m); err != nil {
+        panic(err)
     }
     result := MakeMat(recv.numRows, m.numRows)
     for i := 0; i < result.numRows; i++ {
@@ -234,10 +296,66 @@ func (recv *MottuMat) BroadMul(m *MottuMat) *MottuMat {
     return result
 }
 // Randomize
+// Fills recv with standard normal values. Every call starts from the same
+// seed, so two matrices of the same size come out identical. Use
+// RandomizeWith to draw different values.
 func (recv *MottuMat) Randomize() {
     //time.Now().UnixNano() 
-    r := rand.New( rand.NewSource(1))
+    recv.RandomizeWith(rand.New( rand.NewSource(1)))
+}
+
+// RandomizeWith fills recv with standard normal values drawn from r
+func (recv *MottuMat) RandomizeWith(r *rand.Rand) {
+    recv.RandomizeNormal(r, 0, 1)
+}
+
+// RandomizeNormal fills recv with values drawn from r from a Gaussian
+// distribution with the given mean and standard deviation
+func (recv *MottuMat) RandomizeNormal(r *rand.Rand, mean, std_dev float64) {
+    for i := 0; i < len(recv.data); i++ {
+        recv.data[i] = mean + std_dev*r.NormFloat64()
+    }
+}
+
+// RandomizeUniform fills recv with values drawn from r uniformly in [low, high)
+func (recv *MottuMat) RandomizeUniform(r *rand.Rand, low, high float64) {
+    for i := 0; i < len(recv.data); i++ {
+        recv.data[i] = low + (high-low)*r.Float64()
+    }
+}
+
+// SumSquares returns the sum of the squares of all elements of recv
+func (recv *MottuMat) SumSquares() float64 {
+    return dot(recv.data, recv.data)
+}
+
+// SumAbs returns the sum of the absolute values of all elements of recv
+func (recv *MottuMat) SumAbs() float64 {
+    acc := 0.0
+    for _, v := range recv.data {
+        acc += math.Abs(v)
+    }
+    return acc
+}
+
+// Copy returns a new matrix with the same contents as recv
+func (recv *MottuMat) Copy() *MottuMat {
+    result := MakeMat(recv.numRows, recv.numCols)
+    copy(result.data, recv.data)
+    return result
+}
+
+// CopyFrom overwrites recv with the contents of m
+func (recv *MottuMat) CopyFrom(m *MottuMat) {
+    if err := checkSame("CopyFrom", recv, m); err != nil {
+        panic(err)
+    }
+    copy(recv.data, m.data)
+}
+
+// Zero sets every element of recv to 0
+func (recv *MottuMat) Zero() {
     for i := 0; i < len(recv.data); i++ {
-        recv.data[i] = r.NormFloat64()
+        recv.data[i] = 0
     }
 }
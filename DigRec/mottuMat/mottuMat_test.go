@@ -0,0 +1,165 @@
+package mottuMat
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "testing"
+)
+
+// naive_mul is how the products used to be computed: op(a)*op(b), where op
+// transposes when asked to, by building the transposes and running the
+// plain triple loop
+func naive_mul(a, b *MottuMat, trans_a, trans_b bool) *MottuMat {
+    if trans_a {
+        a = a.Transpose()
+    }
+    if trans_b {
+        b = b.Transpose()
+    }
+    n, m, q := a.numRows, a.numCols, b.numCols
+    result := MakeMat(n, q)
+    for i := 0; i < n; i++ {
+        for j := 0; j < q; j++ {
+            acc := 0.0
+            for k := 0; k < m; k++ {
+                acc += a.data[i*m+k]*b.data[k*q+j]
+            }
+            result.data[i*q+j] = acc
+        }
+    }
+    return result
+}
+
+func random_mat(rows, cols int, r *rand.Rand) *MottuMat {
+    m := MakeMat(rows, cols)
+    m.RandomizeWith(r)
+    return m
+}
+
+func check_close(t *testing.T, op string, got, want *MottuMat) {
+    t.Helper()
+    if got.Shape() != want.Shape() {
+        t.Fatalf("%s: shape %v, want %v", op, got.Shape(), want.Shape())
+    }
+    for i := range want.data {
+        if d := math.Abs(got.data[i] - want.data[i]); d > 1e-12*math.Max(1, math.Abs(want.data[i])) {
+            t.Fatalf("%s: element %d is %g, want %g", op, i, got.data[i], want.data[i])
+        }
+    }
+}
+
+// The tiled products have to agree with the naive loop, including shapes
+// that don't divide into whole tiles
+func TestProductsMatchNaive(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+    shapes := [][3]int{{1, 1, 1}, {1, 5, 1}, {3, 1, 4}, {30, 784, 10}, {67, 130, 259}, {65, 257, 129}}
+    for _, s := range shapes {
+        n, m, q := s[0], s[1], s[2]
+        name := fmt.Sprintf("%dx%dx%d", n, m, q)
+        a, b := random_mat(n, m, r), random_mat(m, q, r)
+        check_close(t, "Mul "+name, a.Mul(b), naive_mul(a, b, false, false))
+        bt := random_mat(q, m, r)
+        check_close(t, "MulT "+name, a.MulT(bt), naive_mul(a, bt, false, true))
+        at := random_mat(m, n, r)
+        check_close(t, "TMul "+name, at.TMul(b), naive_mul(at, b, true, false))
+    }
+}
+
+// check_nonfinite checks got has NaN and Inf entries exactly where want has
+func check_nonfinite(t *testing.T, op string, got, want *MottuMat) {
+    t.Helper()
+    bad := 0
+    for i := range want.data {
+        g, w := got.data[i], want.data[i]
+        if math.IsNaN(w) || math.IsInf(w, 0) {
+            bad++
+        }
+        if math.IsNaN(g) != math.IsNaN(w) || (math.IsInf(w, 0) && g != w) || (math.IsInf(g, 0) && g != w) {
+            t.Fatalf("%s: element %d is %g, want %g", op, i, g, w)
+        }
+    }
+    if bad == 0 {
+        t.Fatalf("%s: nothing to propagate", op)
+    }
+}
+
+// A NaN or Inf in one factor spreads to every result it feeds into, even
+// where the other factor is 0, as 0*Inf is NaN
+func TestProductsPropagateNaN(t *testing.T) {
+    r := rand.New(rand.NewSource(2))
+    for _, s := range [][3]int{{3, 4, 5}, {67, 130, 259}} {
+        n, m, q := s[0], s[1], s[2]
+        for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+            name := fmt.Sprintf("%g %dx%dx%d", v, n, m, q)
+            // a is mostly zeros, the bad value in b meeting zeros and
+            // nonzeros alike
+            a, at := MakeMat(n, m), MakeMat(m, n)
+            a.SetElem(n-1, 2, 1.5)
+            at.SetElem(2, n-1, 1.5)
+            b, bt := random_mat(m, q, r), random_mat(q, m, r)
+            b.SetElem(2, q-1, v)
+            bt.SetElem(q-1, 2, v)
+            check_nonfinite(t, "Mul "+name, a.Mul(b), naive_mul(a, b, false, false))
+            check_nonfinite(t, "MulT "+name, a.MulT(bt), naive_mul(a, bt, false, true))
+            check_nonfinite(t, "TMul "+name, at.TMul(b), naive_mul(at, b, true, false))
+            // and the other way around, the bad value in a
+            check_nonfinite(t, "Mul "+name+" in a", b.Transpose().Mul(at), naive_mul(b.Transpose(), at, false, false))
+
+            x := MakeColVec(m)
+            x.SetElem(2, 0, v)
+            check_nonfinite(t, "EvalLinMatExp "+name, EvalLinMatExp(a, x, MakeColVec(n)), naive_mul(a, x, false, false))
+        }
+    }
+}
+
+// The benchmark shapes are a layer's weights against a mini batch of 100
+// samples, as in training, plus a large square product
+var benchShapes = []struct {
+    name string
+    out, in, batch int
+}{
+    {"784x30", 30, 784, 100},
+    {"784x100", 100, 784, 100},
+    {"1000x1000", 1000, 1000, 1000},
+}
+
+// bench_product times fast against the naive loop on every shape. make_args
+// returns the operands for a shape.
+func bench_product(b *testing.B, trans_a, trans_b bool, fast func(x, y *MottuMat) *MottuMat,
+                   make_args func(out, in, batch int, r *rand.Rand) (*MottuMat, *MottuMat)) {
+    for _, s := range benchShapes {
+        x, y := make_args(s.out, s.in, s.batch, rand.New(rand.NewSource(1)))
+        b.Run(s.name+"/gemm", func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                fast(x, y)
+            }
+        })
+        b.Run(s.name+"/naive", func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                naive_mul(x, y, trans_a, trans_b)
+            }
+        })
+    }
+}
+
+// Feeding a batch forward: w.x
+func BenchmarkMul(b *testing.B) {
+    bench_product(b, false, false, (*MottuMat).Mul, func(out, in, batch int, r *rand.Rand) (*MottuMat, *MottuMat) {
+        return random_mat(out, in, r), random_mat(in, batch, r)
+    })
+}
+
+// The weight gradient: delta.x^T
+func BenchmarkMulT(b *testing.B) {
+    bench_product(b, false, true, (*MottuMat).MulT, func(out, in, batch int, r *rand.Rand) (*MottuMat, *MottuMat) {
+        return random_mat(out, batch, r), random_mat(in, batch, r)
+    })
+}
+
+// Backpropagating delta: w^T.delta
+func BenchmarkTMul(b *testing.B) {
+    bench_product(b, true, false, (*MottuMat).TMul, func(out, in, batch int, r *rand.Rand) (*MottuMat, *MottuMat) {
+        return random_mat(out, in, r), random_mat(out, batch, r)
+    })
+}
@@ -0,0 +1,225 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Activation is the non-linearity applied to the weighted inputs z of a layer.
+// Matrices passed in hold one sample per column.
+type Activation interface {
+    // Name identifies the activation when a model is saved. Parameterized
+    // activations include the parameter, e.g. "leaky_relu:0.01"
+    Name() string
+    // Value returns a = f(z)
+    Value(z *mottuMat.MottuMat) *mottuMat.MottuMat
+    // Derivative takes delta = partial C/partial a and returns partial C/partial z.
+    // For element-wise activations this is delta (.) f'(z). a is f(z), passed
+    // in so it doesn't have to be recomputed.
+    Derivative(z, a, delta *mottuMat.MottuMat) *mottuMat.MottuMat
+}
+
+// elementwise is an Activation that applies f to every entry on its own
+type elementwise struct {
+    name string
+    f func(float64) float64
+    fp func(float64) float64
+}
+
+func (e *elementwise) Name() string {
+    return e.name
+}
+
+func (e *elementwise) Value(z *mottuMat.MottuMat) *mottuMat.MottuMat {
+    return z.ApplyFunc(e.f)
+}
+
+func (e *elementwise) Derivative(z, a, delta *mottuMat.MottuMat) *mottuMat.MottuMat {
+    sp := z.ApplyFunc(e.fp)
+    sp.HadMulEq(delta)
+    return sp
+}
+
+var (
+    Sigmoid Activation = &elementwise{"sigmoid", sigmoid, sigmoid_prime}
+    Tanh Activation = &elementwise{"tanh", math.Tanh, tanh_prime}
+    ReLU Activation = &elementwise{"relu", relu, relu_prime}
+    Identity Activation = &elementwise{"identity", identity, identity_prime}
+    Softplus Activation = &elementwise{"softplus", softplus, sigmoid}
+    GELU Activation = &elementwise{"gelu", gelu, gelu_prime}
+    Softmax Activation = softmax{}
+)
+
+// MakeLeakyReLU returns max(x, alpha*x) for 0 < alpha < 1
+func MakeLeakyReLU(alpha float64) Activation {
+    return &elementwise{
+        name: "leaky_relu:" + strconv.FormatFloat(alpha, 'g', -1, 64),
+        f: func(z float64) float64 {
+            if z > 0 {
+                return z
+            }
+            return alpha*z
+        },
+        fp: func(z float64) float64 {
+            if z > 0 {
+                return 1
+            }
+            return alpha
+        },
+    }
+}
+
+// MakeELU returns the exponential linear unit with the given alpha
+func MakeELU(alpha float64) Activation {
+    return &elementwise{
+        name: "elu:" + strconv.FormatFloat(alpha, 'g', -1, 64),
+        f: func(z float64) float64 {
+            if z > 0 {
+                return z
+            }
+            return alpha*(math.Exp(z)-1)
+        },
+        fp: func(z float64) float64 {
+            if z > 0 {
+                return 1
+            }
+            return alpha*math.Exp(z)
+        },
+    }
+}
+
+// ParseActivation returns the activation with the given name. It accepts the
+// names returned by Activation.Name. leaky_relu and elu default to an alpha of
+// 0.01 and 1 when no parameter is given.
+func ParseActivation(name string) (Activation, error) {
+    base, param, has_param := strings.Cut(strings.ToLower(strings.TrimSpace(name)), ":")
+    alpha := 0.0
+    if has_param {
+        var err error
+        if alpha, err = strconv.ParseFloat(param, 64); err != nil {
+            return nil, fmt.Errorf("network: bad parameter for activation %q: %w", name, err)
+        }
+    }
+    switch base {
+    case "sigmoid":
+        return Sigmoid, nil
+    case "tanh":
+        return Tanh, nil
+    case "relu":
+        return ReLU, nil
+    case "identity", "linear":
+        return Identity, nil
+    case "softplus":
+        return Softplus, nil
+    case "gelu":
+        return GELU, nil
+    case "softmax":
+        return Softmax, nil
+    case "leaky_relu", "leakyrelu":
+        if !has_param {
+            alpha = 0.01
+        }
+        return MakeLeakyReLU(alpha), nil
+    case "elu":
+        if !has_param {
+            alpha = 1
+        }
+        return MakeELU(alpha), nil
+    }
+    return nil, fmt.Errorf("network: unknown activation %q", name)
+}
+
+// softmax normalizes each column into a probability distribution. Unlike the
+// others it isn't element-wise: every output depends on every input.
+type softmax struct{}
+
+func (softmax) Name() string {
+    return "softmax"
+}
+
+func (softmax) Value(z *mottuMat.MottuMat) *mottuMat.MottuMat {
+    result := mottuMat.MakeMat(z.Rows(), z.Cols())
+    for j := 0; j < z.Cols(); j++ {
+        // Shift by the max so exp can't overflow
+        max_z := math.Inf(-1)
+        for i := 0; i < z.Rows(); i++ {
+            max_z = math.Max(max_z, z.GetElem(i, j))
+        }
+        sum := 0.0
+        for i := 0; i < z.Rows(); i++ {
+            e := math.Exp(z.GetElem(i, j)-max_z)
+            result.SetElem(i, j, e)
+            sum += e
+        }
+        for i := 0; i < z.Rows(); i++ {
+            result.SetElem(i, j, result.GetElem(i, j)/sum)
+        }
+    }
+    return result
+}
+
+// The Jacobian is diag(a) - a a^T, so J^T delta = a (.) (delta - a.delta)
+func (softmax) Derivative(z, a, delta *mottuMat.MottuMat) *mottuMat.MottuMat {
+    result := mottuMat.MakeMat(a.Rows(), a.Cols())
+    for j := 0; j < a.Cols(); j++ {
+        dot := 0.0
+        for i := 0; i < a.Rows(); i++ {
+            dot += a.GetElem(i, j)*delta.GetElem(i, j)
+        }
+        for i := 0; i < a.Rows(); i++ {
+            result.SetElem(i, j, a.GetElem(i, j)*(delta.GetElem(i, j)-dot))
+        }
+    }
+    return result
+}
+
+// ==================== scalar functions ===============
+
+func tanh_prime(z float64) float64 {
+    t := math.Tanh(z)
+    return 1-t*t
+}
+
+func relu(z float64) float64 {
+    if z > 0 {
+        return z
+    }
+    return 0
+}
+
+func relu_prime(z float64) float64 {
+    if z > 0 {
+        return 1
+    }
+    return 0
+}
+
+func identity(z float64) float64 {
+    return z
+}
+
+func identity_prime(z float64) float64 {
+    return 1
+}
+
+// log(1+e^z), written so it doesn't overflow for large z
+func softplus(z float64) float64 {
+    if z > 0 {
+        return z + math.Log1p(math.Exp(-z))
+    }
+    return math.Log1p(math.Exp(z))
+}
+
+// gelu is z*Phi(z) where Phi is the standard normal CDF
+func gelu(z float64) float64 {
+    return 0.5*z*(1+math.Erf(z/math.Sqrt2))
+}
+
+func gelu_prime(z float64) float64 {
+    cdf := 0.5*(1+math.Erf(z/math.Sqrt2))
+    pdf := math.Exp(-0.5*z*z)/math.Sqrt(2*math.Pi)
+    return cdf + z*pdf
+}
@@ -0,0 +1,199 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Keeps the normalization away from dividing by 0 for constant inputs
+const bnEpsilon = 1e-5
+
+// BatchNorm normalizes every input to mean 0 and variance 1 over the batch,
+// then scales and shifts it by the learned gamma and beta. While training it
+// keeps running averages of the batch means and variances, which FeedForward
+// and Evaluate use in place of batch statistics.
+//
+// The statistics are taken over the whole mini batch, so networks with a
+// BatchNorm layer are always trained vectorized and on a single worker.
+type BatchNorm struct {
+    size int
+    momentum float64
+    gamma *mottuMat.MottuMat
+    beta *mottuMat.MottuMat
+    running_mean *mottuMat.MottuMat
+    running_var *mottuMat.MottuMat
+    grad_gamma, grad_beta *mottuMat.MottuMat
+}
+
+type batchnorm_cache struct {
+    xhat *mottuMat.MottuMat
+    mean []float64
+    variance []float64
+}
+
+// MakeBatchNorm returns a batch normalization layer for inputs of the given
+// size. After every batch the running statistics move to
+// momentum*running + (1-momentum)*batch.
+func MakeBatchNorm(size int, momentum float64) *BatchNorm {
+    if momentum < 0 || momentum >= 1 {
+        panic(fmt.Sprintf("Batch norm momentum must be in [0, 1), got %g", momentum))
+    }
+    b := &BatchNorm{
+        size: size,
+        momentum: momentum,
+        gamma: mottuMat.MakeColVec(size),
+        beta: mottuMat.MakeColVec(size),
+        running_mean: mottuMat.MakeColVec(size),
+        running_var: mottuMat.MakeColVec(size),
+        grad_gamma: mottuMat.MakeColVec(size),
+        grad_beta: mottuMat.MakeColVec(size),
+    }
+    for i := 0; i < size; i++ {
+        b.gamma.SetElem(i, 0, 1)
+        b.running_var.SetElem(i, 0, 1)
+    }
+    return b
+}
+
+func (b *BatchNorm) InputSize() int {
+    return b.size
+}
+
+func (b *BatchNorm) OutputSize() int {
+    return b.size
+}
+
+// Gamma and beta aren't weights, so they are left out of regularization
+func (b *BatchNorm) Params() []Param {
+    return []Param{{Value: b.gamma, Grad: b.grad_gamma}, {Value: b.beta, Grad: b.grad_beta}}
+}
+
+func (b *BatchNorm) Grads() []*mottuMat.MottuMat {
+    return []*mottuMat.MottuMat{b.grad_gamma, b.grad_beta}
+}
+
+// RunningMean returns the mean of every input used by FeedForward
+func (b *BatchNorm) RunningMean() *mottuMat.MottuMat {
+    return b.running_mean
+}
+
+// RunningVar returns the variance of every input used by FeedForward
+func (b *BatchNorm) RunningVar() *mottuMat.MottuMat {
+    return b.running_var
+}
+
+func (b *BatchNorm) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    m := x.Cols()
+    mean := make([]float64, b.size)
+    variance := make([]float64, b.size)
+    for i := 0; i < b.size; i++ {
+        if !train {
+            mean[i] = b.running_mean.GetElem(i, 0)
+            variance[i] = b.running_var.GetElem(i, 0)
+            continue
+        }
+        for j := 0; j < m; j++ {
+            mean[i] += x.GetElem(i, j)
+        }
+        mean[i] /= float64(m)
+        for j := 0; j < m; j++ {
+            d := x.GetElem(i, j) - mean[i]
+            variance[i] += d*d
+        }
+        variance[i] /= float64(m)
+    }
+
+    xhat := mottuMat.MakeMat(b.size, m)
+    y := mottuMat.MakeMat(b.size, m)
+    for i := 0; i < b.size; i++ {
+        inv_std := 1/math.Sqrt(variance[i] + bnEpsilon)
+        gamma, beta := b.gamma.GetElem(i, 0), b.beta.GetElem(i, 0)
+        for j := 0; j < m; j++ {
+            xh := (x.GetElem(i, j) - mean[i])*inv_std
+            xhat.SetElem(i, j, xh)
+            y.SetElem(i, j, gamma*xh + beta)
+        }
+    }
+    return y, &batchnorm_cache{xhat, mean, variance}
+}
+
+// The mean and variance depend on every sample of the batch, so each input
+// gets partial C/partial x = gamma/(m*std) * (m*dy - sum dy - xhat*sum dy*xhat)
+func (b *BatchNorm) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    bc := cache.(*batchnorm_cache)
+    m := delta.Cols()
+    dx := mottuMat.MakeMat(b.size, m)
+    nabla_gamma := mottuMat.MakeColVec(b.size)
+    nabla_beta := mottuMat.MakeColVec(b.size)
+    for i := 0; i < b.size; i++ {
+        sum_dy, sum_dy_xhat := 0.0, 0.0
+        for j := 0; j < m; j++ {
+            dy := delta.GetElem(i, j)
+            sum_dy += dy
+            sum_dy_xhat += dy*bc.xhat.GetElem(i, j)
+        }
+        nabla_gamma.SetElem(i, 0, sum_dy_xhat)
+        nabla_beta.SetElem(i, 0, sum_dy)
+
+        scale := b.gamma.GetElem(i, 0)/(float64(m)*math.Sqrt(bc.variance[i] + bnEpsilon))
+        for j := 0; j < m; j++ {
+            dx.SetElem(i, j, scale*(float64(m)*delta.GetElem(i, j) - sum_dy - bc.xhat.GetElem(i, j)*sum_dy_xhat))
+        }
+    }
+    return dx, []*mottuMat.MottuMat{nabla_gamma, nabla_beta}
+}
+
+// update folds the statistics of the training passes into the running
+// averages. The variances are made unbiased first.
+func (b *BatchNorm) update(caches []interface{}) {
+    for _, cache := range caches {
+        bc := cache.(*batchnorm_cache)
+        m := float64(bc.xhat.Cols())
+        for i := 0; i < b.size; i++ {
+            variance := bc.variance[i]
+            if m > 1 {
+                variance *= m/(m-1)
+            }
+            b.running_mean.SetElem(i, 0, b.momentum*b.running_mean.GetElem(i, 0) + (1-b.momentum)*bc.mean[i])
+            b.running_var.SetElem(i, 0, b.momentum*b.running_var.GetElem(i, 0) + (1-b.momentum)*variance)
+        }
+    }
+}
+
+func (b *BatchNorm) state() []*mottuMat.MottuMat {
+    return []*mottuMat.MottuMat{b.running_mean, b.running_var}
+}
+
+// SetBatchNorm normalizes a hidden layer of a network made of Dense layers,
+// such as one made by MakeMottuNet or MakeLeNet. layer counts the Dense
+// layers from 1 like for SetDropout, so it must be between 1 and the number
+// of Dense layers less one. A BatchNorm layer goes right after the Dense
+// layer, in front of its activation; calling it again only changes the
+// momentum.
+func (this *mottuNet) SetBatchNorm(layer int, momentum float64) {
+    layers := this.model.layers
+    pos, dense := -1, 0
+    for i, l := range layers {
+        if _, ok := l.(*Dense); ok {
+            dense++
+            if dense == layer {
+                pos = i
+            }
+        }
+    }
+    if layer < 1 || layer > dense-1 {
+        panic(fmt.Sprintf("Batch norm only applies to hidden layers, not layer %d", layer))
+    }
+    pos++
+    if b, ok := layers[pos].(*BatchNorm); ok {
+        if momentum < 0 || momentum >= 1 {
+            panic(fmt.Sprintf("Batch norm momentum must be in [0, 1), got %g", momentum))
+        }
+        b.momentum = momentum
+        return
+    }
+    bn := MakeBatchNorm(layers[pos-1].OutputSize(), momentum)
+    this.model = MakeSequential(append(layers[:pos:pos], append([]Layer{bn}, layers[pos:]...)...)...)
+}
@@ -0,0 +1,203 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// layer_kinds returns the types of the layers of a network
+func layer_kinds(mn *mottuNet) []string {
+    var kinds []string
+    for _, l := range mn.model.layers {
+        kinds = append(kinds, fmt.Sprintf("%T", l))
+    }
+    return kinds
+}
+
+func TestSetBatchNorm(t *testing.T) {
+    mn := MakeMottuNet([]int{4, 5, 3, 2})
+    mn.SetBatchNorm(2, 0.9)
+    mn.SetDropout(2, 0.5)
+    mn.SetBatchNorm(1, 0.9)
+    mn.SetBatchNorm(1, 0.5)
+    want := []string{"*network.Dense", "*network.BatchNorm", "*network.ActivationLayer",
+                     "*network.Dense", "*network.BatchNorm", "*network.ActivationLayer", "*network.Dropout",
+                     "*network.Dense", "*network.ActivationLayer"}
+    got := layer_kinds(mn)
+    if len(got) != len(want) {
+        t.Fatalf("layers %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Fatalf("layers %v, want %v", got, want)
+        }
+    }
+    if m := mn.model.layers[1].(*BatchNorm).momentum; m != 0.5 {
+        t.Errorf("momentum %g after setting it again, want 0.5", m)
+    }
+
+    for _, layer := range []int{0, 3} {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("SetBatchNorm(%d) didn't panic", layer)
+                }
+            }()
+            mn.SetBatchNorm(layer, 0.9)
+        }()
+    }
+}
+
+// bn_input returns a batch of 8 samples of 3 inputs, each input with its own
+// mean and spread
+func bn_input() *mottuMat.MottuMat {
+    r := rand.New(rand.NewSource(1))
+    x := mottuMat.MakeMat(3, 8)
+    for i := 0; i < 3; i++ {
+        for j := 0; j < 8; j++ {
+            x.SetElem(i, j, float64(i*5) - 2 + float64(i+1)*r.NormFloat64())
+        }
+    }
+    return x
+}
+
+// row_stats returns the mean and biased variance of row i of m
+func row_stats(m *mottuMat.MottuMat, i int) (float64, float64) {
+    mean, variance := 0.0, 0.0
+    for j := 0; j < m.Cols(); j++ {
+        mean += m.GetElem(i, j)
+    }
+    mean /= float64(m.Cols())
+    for j := 0; j < m.Cols(); j++ {
+        d := m.GetElem(i, j) - mean
+        variance += d*d
+    }
+    return mean, variance/float64(m.Cols())
+}
+
+// Training normalizes every input over the batch before scaling by gamma
+// and shifting by beta
+func TestBatchNormForward(t *testing.T) {
+    x := bn_input()
+    b := MakeBatchNorm(3, 0.9)
+    for i := 0; i < 3; i++ {
+        b.gamma.SetElem(i, 0, float64(i+2))
+        b.beta.SetElem(i, 0, float64(i) - 1)
+    }
+    y, _ := b.Forward(x, true, nil)
+    for i := 0; i < 3; i++ {
+        _, x_var := row_stats(x, i)
+        mean, variance := row_stats(y, i)
+        gamma := float64(i+2)
+        want_var := gamma*gamma*x_var/(x_var + bnEpsilon)
+        if math.Abs(mean - (float64(i) - 1)) > 1e-12 || math.Abs(variance - want_var) > 1e-9 {
+            t.Errorf("input %d: mean %g variance %g, want %g and %g", i, mean, variance, float64(i) - 1, want_var)
+        }
+    }
+}
+
+// The running statistics move towards the batch ones by 1-momentum, with
+// the variance unbiased, and inference normalizes with them
+func TestBatchNormRunningStats(t *testing.T) {
+    x := bn_input()
+    b := MakeBatchNorm(3, 0.25)
+    _, cache := b.Forward(x, true, nil)
+    b.update([]interface{}{cache})
+    b.update([]interface{}{cache})
+    for i := 0; i < 3; i++ {
+        mean, variance := row_stats(x, i)
+        variance *= 8.0/7
+        // Starting from mean 0 and variance 1, two updates leave 1/16
+        want_mean := 15.0/16*mean
+        want_var := 1.0/16 + 15.0/16*variance
+        if math.Abs(b.RunningMean().GetElem(i, 0) - want_mean) > 1e-12 || math.Abs(b.RunningVar().GetElem(i, 0) - want_var) > 1e-12 {
+            t.Errorf("input %d: running mean %g variance %g, want %g and %g", i,
+                     b.RunningMean().GetElem(i, 0), b.RunningVar().GetElem(i, 0), want_mean, want_var)
+        }
+    }
+
+    b.gamma.SetElem(1, 0, 3)
+    b.beta.SetElem(1, 0, -2)
+    y, _ := b.Forward(x, false, nil)
+    for i := 0; i < 3; i++ {
+        for j := 0; j < 8; j++ {
+            std := math.Sqrt(b.RunningVar().GetElem(i, 0) + bnEpsilon)
+            want := b.gamma.GetElem(i, 0)*(x.GetElem(i, j) - b.RunningMean().GetElem(i, 0))/std + b.beta.GetElem(i, 0)
+            if math.Abs(y.GetElem(i, j) - want) > 1e-12 {
+                t.Errorf("inference (%d, %d) gives %g, want %g", i, j, y.GetElem(i, j), want)
+            }
+        }
+    }
+}
+
+// Backward gives the derivatives of sum(c*y) with respect to the input,
+// gamma and beta, the input reaching every output through the batch
+// statistics
+func TestBatchNormBackward(t *testing.T) {
+    const h = 1e-6
+    x := bn_input()
+    b := MakeBatchNorm(3, 0.9)
+    b.gamma.RandomizeWith(rand.New(rand.NewSource(2)))
+    b.beta.RandomizeWith(rand.New(rand.NewSource(3)))
+    c := mottuMat.MakeMat(3, 8)
+    c.RandomizeWith(rand.New(rand.NewSource(4)))
+    loss := func() float64 {
+        y, _ := b.Forward(x, true, nil)
+        sum := 0.0
+        for i := 0; i < y.Rows(); i++ {
+            for j := 0; j < y.Cols(); j++ {
+                sum += c.GetElem(i, j)*y.GetElem(i, j)
+            }
+        }
+        return sum
+    }
+
+    _, cache := b.Forward(x, true, nil)
+    dx, grads := b.Backward(cache, c)
+    for k, m := range []*mottuMat.MottuMat{x, b.gamma, b.beta} {
+        want_grad := dx
+        if k > 0 {
+            want_grad = grads[k-1]
+        }
+        for i := 0; i < m.Rows(); i++ {
+            for j := 0; j < m.Cols(); j++ {
+                v := m.GetElem(i, j)
+                m.SetElem(i, j, v+h)
+                plus := loss()
+                m.SetElem(i, j, v-h)
+                minus := loss()
+                m.SetElem(i, j, v)
+                want := (plus - minus)/(2*h)
+                if got := want_grad.GetElem(i, j); math.Abs(got - want) > 1e-6*math.Max(1, math.Abs(want)) {
+                    t.Errorf("matrix %d (%d, %d): gradient %g, want %g", k, i, j, got, want)
+                }
+            }
+        }
+    }
+}
+
+// The statistics are over the whole mini batch, so the number of workers
+// changes nothing
+func TestBatchNormWorkers(t *testing.T) {
+    set := test_set(40, 6, 3, 1)
+    var nets [2]*mottuNet
+    for i, workers := range []int{1, 4} {
+        nets[i] = MakeMottuNet([]int{6, 5, 3})
+        nets[i].SetBatchNorm(1, 0.9)
+        nets[i].SetRand(rand.New(rand.NewSource(5)))
+        nets[i].SetWorkers(workers)
+        nets[i].SetHooks(&record_hooks{})
+        nets[i].SGD(set, 2, 10, 0.5)
+    }
+    if d := max_param_diff(nets[0], nets[1]); d != 0 {
+        t.Errorf("4 workers gave params %g away from 1 worker", d)
+    }
+    for i, m := range nets[0].model.layers[1].(statefulLayer).state() {
+        if d := m.Sub(nets[1].model.layers[1].(statefulLayer).state()[i]).SumAbs(); d != 0 {
+            t.Errorf("running statistic %d differs by %g", i, d)
+        }
+    }
+}
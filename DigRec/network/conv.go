@@ -0,0 +1,415 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Volume is the shape of a stack of images: Channels images of Height x
+// Width. A sample is stored as one column, channel by channel and row by row
+// within a channel, the same layout mnist.Set uses for a single image.
+type Volume struct {
+    Channels int
+    Height int
+    Width int
+}
+
+// Size returns the number of values in the volume
+func (v Volume) Size() int {
+    return v.Channels*v.Height*v.Width
+}
+
+func (v Volume) String() string {
+    return fmt.Sprintf("%dx%dx%d", v.Channels, v.Height, v.Width)
+}
+
+// SetFeatures replaces the layers in front of the first Dense layer, e.g.
+// with convolution and pooling over images. The input of the network becomes
+// the input of the first feature layer, and the output of the last one has
+// to match the input of the first Dense layer.
+func (this *mottuNet) SetFeatures(layers ...Layer) {
+    first := -1
+    for i, l := range this.model.layers {
+        if _, ok := l.(*Dense); ok {
+            first = i
+            break
+        }
+    }
+    if first < 0 {
+        panic("Feature layers go in front of a Dense layer")
+    }
+    if len(layers) > 0 && layers[len(layers)-1].OutputSize() != this.model.layers[first].InputSize() {
+        panic(fmt.Sprintf("Feature layers output %d values but the first dense layer takes %d",
+                          layers[len(layers)-1].OutputSize(), this.model.layers[first].InputSize()))
+    }
+    this.model = MakeSequential(append(append([]Layer(nil), layers...), this.model.layers[first:]...)...)
+}
+
+// MakeLeNet returns a LeNet style network for images of shape in: two
+// rounds of 5x5 ReLU convolution and 2x2 max pooling (20 then 50 channels),
+// a dense ReLU layer of 500 and a softmax output layer of 10 trained with the
+// log-likelihood cost. All layers draw their initial values from r, with He
+// initialization for the ReLU layers and Xavier for the softmax one.
+func MakeLeNet(in Volume, r *rand.Rand) *mottuNet {
+    conv1 := MakeConv2D(in, 20, 5, 1, 0, ReLU, r)
+    pool1 := MakeMaxPool(conv1.OutputVolume(), 2, 2)
+    conv2 := MakeConv2D(pool1.OutputVolume(), 50, 5, 1, 0, ReLU, r)
+    pool2 := MakeMaxPool(conv2.OutputVolume(), 2, 2)
+    flatten := MakeFlatten(pool2.OutputVolume())
+    hidden := MakeDense(flatten.OutputSize(), 500)
+    hidden.Initialize(He, r)
+    output := MakeDense(500, 10)
+    output.Initialize(Xavier, r)
+
+    retval := MakeMottuNetFromLayers(conv1, pool1, conv2, pool2, flatten,
+                                     hidden, MakeActivationLayer(500, ReLU),
+                                     output, MakeActivationLayer(10, Softmax))
+    retval.SetCost(LogLikelihood)
+    return retval
+}
+
+// ==================== Conv2D ===============
+
+// Conv2D convolves its input with a set of kernels, each spanning all the
+// input channels, adds a bias per output channel and applies an activation
+type Conv2D struct {
+    in Volume
+    out Volume
+    kernel int
+    stride int
+    padding int
+    act Activation
+    kernels *mottuMat.MottuMat // row o holds kernel o, laid out like a Volume
+    bias *mottuMat.MottuMat
+    grad_kernels, grad_bias *mottuMat.MottuMat
+}
+
+type conv_cache struct {
+    cols *mottuMat.MottuMat
+    z *mottuMat.MottuMat
+    a *mottuMat.MottuMat
+}
+
+// MakeConv2D returns a convolution over inputs of shape in with square
+// kernels of the given size. The output of every kernel is
+// (in.Height + 2*padding - kernel)/stride + 1 high, and as wide. The kernels
+// are drawn from r with He initialization and the biases start at 0.
+func MakeConv2D(in Volume, out_channels, kernel, stride, padding int, act Activation, r *rand.Rand) *Conv2D {
+    c := make_conv2d(in, out_channels, kernel, stride, padding, act)
+    c.Initialize(He, r)
+    return c
+}
+
+// make_conv2d is MakeConv2D with the kernels and biases left at 0
+func make_conv2d(in Volume, out_channels, kernel, stride, padding int, act Activation) *Conv2D {
+    if kernel <= 0 || stride <= 0 || padding < 0 || out_channels <= 0 {
+        panic("Invalid convolution parameters")
+    }
+    out_h := (in.Height + 2*padding - kernel)/stride + 1
+    out_w := (in.Width + 2*padding - kernel)/stride + 1
+    if out_h <= 0 || out_w <= 0 {
+        panic(fmt.Sprintf("Kernel of %d doesn't fit an input of %v", kernel, in))
+    }
+    return &Conv2D{
+        in: in,
+        out: Volume{out_channels, out_h, out_w},
+        kernel: kernel,
+        stride: stride,
+        padding: padding,
+        act: act,
+        kernels: mottuMat.MakeMat(out_channels, in.Channels*kernel*kernel),
+        bias: mottuMat.MakeColVec(out_channels),
+        grad_kernels: mottuMat.MakeMat(out_channels, in.Channels*kernel*kernel),
+        grad_bias: mottuMat.MakeColVec(out_channels),
+    }
+}
+
+// Initialize redraws the kernels and biases
+func (c *Conv2D) Initialize(init Initializer, r *rand.Rand) {
+    init.InitWeights(c.kernels, c.kernels.Cols(), c.out.Channels*c.kernel*c.kernel, r)
+    init.InitBiases(c.bias, r)
+}
+
+func (c *Conv2D) InputSize() int {
+    return c.in.Size()
+}
+
+func (c *Conv2D) OutputSize() int {
+    return c.out.Size()
+}
+
+// OutputVolume returns the shape of the output
+func (c *Conv2D) OutputVolume() Volume {
+    return c.out
+}
+
+func (c *Conv2D) Params() []Param {
+    return []Param{{Value: c.kernels, Grad: c.grad_kernels, IsWeight: true}, {Value: c.bias, Grad: c.grad_bias}}
+}
+
+func (c *Conv2D) Grads() []*mottuMat.MottuMat {
+    return []*mottuMat.MottuMat{c.grad_kernels, c.grad_bias}
+}
+
+func (c *Conv2D) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    // Unroll every patch the kernels see into a column, so the whole
+    // convolution is one matrix product
+    cols := c.im2col(x)
+    out_area := c.out.Height*c.out.Width
+    prod := c.kernels.Mul(cols)
+    prod.AddColEq(c.bias)
+
+    // prod has a row per channel and a column per (sample, position)
+    z := mottuMat.MakeMat(c.out.Size(), x.Cols())
+    for o := 0; o < c.out.Channels; o++ {
+        for b := 0; b < x.Cols(); b++ {
+            for p := 0; p < out_area; p++ {
+                z.SetElem(o*out_area+p, b, prod.GetElem(o, b*out_area+p))
+            }
+        }
+    }
+    a := c.act.Value(z)
+    return a, &conv_cache{cols, z, a}
+}
+
+func (c *Conv2D) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    cc := cache.(*conv_cache)
+    dz := c.act.Derivative(cc.z, cc.a, delta)
+    batch := delta.Cols()
+    out_area := c.out.Height*c.out.Width
+
+    dprod := mottuMat.MakeMat(c.out.Channels, batch*out_area)
+    for o := 0; o < c.out.Channels; o++ {
+        for b := 0; b < batch; b++ {
+            for p := 0; p < out_area; p++ {
+                dprod.SetElem(o, b*out_area+p, dz.GetElem(o*out_area+p, b))
+            }
+        }
+    }
+    nabla_k := dprod.MulT(cc.cols)
+    nabla_b := dprod.SumCols()
+    dx := c.col2im(c.kernels.TMul(dprod), batch)
+    return dx, []*mottuMat.MottuMat{nabla_k, nabla_b}
+}
+
+// im2col returns a matrix with a row per (channel, kernel row, kernel col)
+// and a column per (sample, output position). Padding reads as 0.
+func (c *Conv2D) im2col(x *mottuMat.MottuMat) *mottuMat.MottuMat {
+    k := c.kernel
+    out_area := c.out.Height*c.out.Width
+    cols := mottuMat.MakeMat(c.in.Channels*k*k, x.Cols()*out_area)
+    c.each_tap(x.Cols(), func(row, col, in_idx, b int) {
+        cols.SetElem(row, col, x.GetElem(in_idx, b))
+    })
+    return cols
+}
+
+// col2im is the adjoint of im2col: it adds every entry of cols back onto the
+// input position it was read from
+func (c *Conv2D) col2im(cols *mottuMat.MottuMat, batch int) *mottuMat.MottuMat {
+    dx := mottuMat.MakeMat(c.in.Size(), batch)
+    c.each_tap(batch, func(row, col, in_idx, b int) {
+        dx.SetElem(in_idx, b, dx.GetElem(in_idx, b) + cols.GetElem(row, col))
+    })
+    return dx
+}
+
+// each_tap calls f for every place a kernel weight touches an input value
+// that isn't padding, with the im2col row and column and the input row and
+// sample it corresponds to
+func (c *Conv2D) each_tap(batch int, f func(row, col, in_idx, b int)) {
+    k := c.kernel
+    in_area := c.in.Height*c.in.Width
+    out_area := c.out.Height*c.out.Width
+    for b := 0; b < batch; b++ {
+        for ch := 0; ch < c.in.Channels; ch++ {
+            for ky := 0; ky < k; ky++ {
+                for kx := 0; kx < k; kx++ {
+                    row := (ch*k + ky)*k + kx
+                    for oy := 0; oy < c.out.Height; oy++ {
+                        iy := oy*c.stride - c.padding + ky
+                        if iy < 0 || iy >= c.in.Height {
+                            continue
+                        }
+                        for ox := 0; ox < c.out.Width; ox++ {
+                            ix := ox*c.stride - c.padding + kx
+                            if ix < 0 || ix >= c.in.Width {
+                                continue
+                            }
+                            f(row, b*out_area + oy*c.out.Width + ox, ch*in_area + iy*c.in.Width + ix, b)
+                        }
+                    }
+                }
+            }
+        }
+    }
+}
+
+// ==================== Pooling ===============
+
+// Pool downsamples every channel on its own by taking the max or the
+// average of each size x size window
+type Pool struct {
+    in Volume
+    out Volume
+    size int
+    stride int
+    max bool
+}
+
+// MakeMaxPool returns a max pooling layer over inputs of shape in
+func MakeMaxPool(in Volume, size, stride int) *Pool {
+    return make_pool(in, size, stride, true)
+}
+
+// MakeAvgPool returns an average pooling layer over inputs of shape in
+func MakeAvgPool(in Volume, size, stride int) *Pool {
+    return make_pool(in, size, stride, false)
+}
+
+func make_pool(in Volume, size, stride int, max bool) *Pool {
+    if size <= 0 || stride <= 0 || size > in.Height || size > in.Width {
+        panic(fmt.Sprintf("Invalid pooling window %d with stride %d over %v", size, stride, in))
+    }
+    out := Volume{in.Channels, (in.Height-size)/stride + 1, (in.Width-size)/stride + 1}
+    return &Pool{in, out, size, stride, max}
+}
+
+func (p *Pool) InputSize() int {
+    return p.in.Size()
+}
+
+func (p *Pool) OutputSize() int {
+    return p.out.Size()
+}
+
+// OutputVolume returns the shape of the output
+func (p *Pool) OutputVolume() Volume {
+    return p.out
+}
+
+// Max says whether the layer takes the max of each window rather than the
+// average
+func (p *Pool) Max() bool {
+    return p.max
+}
+
+func (p *Pool) Params() []Param {
+    return nil
+}
+
+func (p *Pool) Grads() []*mottuMat.MottuMat {
+    return nil
+}
+
+// For max pooling the cache holds, for every output value, the input row it
+// was taken from
+func (p *Pool) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    result := mottuMat.MakeMat(p.out.Size(), x.Cols())
+    var argmax []int
+    if p.max {
+        argmax = make([]int, p.out.Size()*x.Cols())
+    }
+    area := float64(p.size*p.size)
+    p.each_window(x.Cols(), func(out_idx, b int, window []int) {
+        if p.max {
+            best := window[0]
+            best_val := math.Inf(-1)
+            for _, in_idx := range window {
+                if v := x.GetElem(in_idx, b); v > best_val {
+                    best, best_val = in_idx, v
+                }
+            }
+            argmax[b*p.out.Size()+out_idx] = best
+            result.SetElem(out_idx, b, best_val)
+            return
+        }
+        sum := 0.0
+        for _, in_idx := range window {
+            sum += x.GetElem(in_idx, b)
+        }
+        result.SetElem(out_idx, b, sum/area)
+    })
+    return result, argmax
+}
+
+func (p *Pool) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    dx := mottuMat.MakeMat(p.in.Size(), delta.Cols())
+    argmax, _ := cache.([]int)
+    area := float64(p.size*p.size)
+    p.each_window(delta.Cols(), func(out_idx, b int, window []int) {
+        d := delta.GetElem(out_idx, b)
+        if p.max {
+            in_idx := argmax[b*p.out.Size()+out_idx]
+            dx.SetElem(in_idx, b, dx.GetElem(in_idx, b) + d)
+            return
+        }
+        for _, in_idx := range window {
+            dx.SetElem(in_idx, b, dx.GetElem(in_idx, b) + d/area)
+        }
+    })
+    return dx, nil
+}
+
+// each_window calls f for every output value with the input rows of its window
+func (p *Pool) each_window(batch int, f func(out_idx, b int, window []int)) {
+    in_area := p.in.Height*p.in.Width
+    out_area := p.out.Height*p.out.Width
+    window := make([]int, 0, p.size*p.size)
+    for b := 0; b < batch; b++ {
+        for ch := 0; ch < p.in.Channels; ch++ {
+            for oy := 0; oy < p.out.Height; oy++ {
+                for ox := 0; ox < p.out.Width; ox++ {
+                    window = window[:0]
+                    for dy := 0; dy < p.size; dy++ {
+                        for dx := 0; dx < p.size; dx++ {
+                            iy := oy*p.stride + dy
+                            ix := ox*p.stride + dx
+                            window = append(window, ch*in_area + iy*p.in.Width + ix)
+                        }
+                    }
+                    f(ch*out_area + oy*p.out.Width + ox, b, window)
+                }
+            }
+        }
+    }
+}
+
+// ==================== Flatten ===============
+
+// Flatten marks where image shaped data turns into a plain vector for the
+// dense layers. Samples are already stored flat, so it passes values through.
+type Flatten struct {
+    in Volume
+}
+
+// MakeFlatten returns a Flatten layer for inputs of shape in
+func MakeFlatten(in Volume) *Flatten {
+    return &Flatten{in}
+}
+
+func (f *Flatten) InputSize() int {
+    return f.in.Size()
+}
+
+func (f *Flatten) OutputSize() int {
+    return f.in.Size()
+}
+
+func (f *Flatten) Params() []Param {
+    return nil
+}
+
+func (f *Flatten) Grads() []*mottuMat.MottuMat {
+    return nil
+}
+
+func (f *Flatten) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    return x, nil
+}
+
+func (f *Flatten) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    return delta, nil
+}
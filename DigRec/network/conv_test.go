@@ -0,0 +1,54 @@
+package network
+
+import (
+    "math"
+    "math/rand"
+    "os"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+)
+
+func TestLeNetInit(t *testing.T) {
+    mn := MakeLeNet(Volume{1, 28, 28}, rand.New(rand.NewSource(1)))
+    layers := mn.Model().Layers()
+    output := layers[len(layers)-2].(*Dense)
+    limit := math.Sqrt(6/float64(500+10))
+    w := output.Weights()
+    for i := 0; i < w.Rows(); i++ {
+        for j := 0; j < w.Cols(); j++ {
+            if math.Abs(w.GetElem(i, j)) > limit {
+                t.Fatalf("output weight %g outside the Xavier limit %g", w.GetElem(i, j), limit)
+            }
+        }
+    }
+    for i := 0; i < output.Biases().Rows(); i++ {
+        if output.Biases().GetElem(i, 0) != 0 {
+            t.Fatal("output biases should start at 0")
+        }
+    }
+}
+
+// TestLeNetMNIST trains LeNet on the real MNIST data and checks it gets over
+// 99% of the test set right. It takes hours on a CPU, so it only runs when
+// DIGREC_MNIST names the directory holding the MNIST files:
+//
+//     DIGREC_MNIST=/path/to/mnist go test -run LeNetMNIST -timeout 0 ./network
+func TestLeNetMNIST(t *testing.T) {
+    dir := os.Getenv("DIGREC_MNIST")
+    if dir == "" || testing.Short() {
+        t.Skip("set DIGREC_MNIST to the MNIST directory to run")
+    }
+    train, test, err := mnist.Load(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    mn := MakeLeNet(Volume{1, mnist.Height, mnist.Width}, rand.New(rand.NewSource(DefaultSeed)))
+    mn.SetWorkers(0)
+    mn.SetRegularization(0, 0.1)
+    mn.SGD(train, 20, 10, 0.03)
+    accuracy := float64(mn.Evaluate(test))/float64(test.Count())
+    t.Logf("test accuracy %.2f%%", 100*accuracy)
+    if accuracy <= 0.99 {
+        t.Errorf("test accuracy %.2f%%, want over 99%%", 100*accuracy)
+    }
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "strings"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Cost measures how far the output activations a are from the expected
+// outputs y. Matrices hold one sample per column.
+type Cost interface {
+    // Name identifies the cost when a model is saved
+    Name() string
+    // Fn returns the cost summed over all the columns of a
+    Fn(a, y *mottuMat.MottuMat) float64
+    // Delta returns partial C/partial z for the output layer, whose weighted
+    // input is z, activation is act and output is a = act(z)
+    Delta(z, a, y *mottuMat.MottuMat, act Activation) *mottuMat.MottuMat
+}
+
+var (
+    // Quadratic is 1/2 ||a-y||^2. It learns slowly when sigmoid outputs saturate.
+    Quadratic Cost = quadraticCost{}
+    // CrossEntropy is the binary cross-entropy summed over the outputs. With a
+    // sigmoid output layer the sigmoid' term cancels out of the delta.
+    CrossEntropy Cost = crossEntropyCost{}
+    // LogLikelihood is the categorical cross-entropy -sum y ln a. It is meant
+    // to be paired with a Softmax output layer, which makes the delta a-y.
+    LogLikelihood Cost = logLikelihoodCost{}
+)
+
+// ParseCost returns the cost with the given name
+func ParseCost(name string) (Cost, error) {
+    switch strings.ToLower(strings.TrimSpace(name)) {
+    case "quadratic", "mse":
+        return Quadratic, nil
+    case "cross_entropy", "crossentropy", "bce":
+        return CrossEntropy, nil
+    case "log_likelihood", "loglikelihood", "categorical_cross_entropy", "cce":
+        return LogLikelihood, nil
+    }
+    return nil, fmt.Errorf("network: unknown cost %q", name)
+}
+
+// Keeps log and division away from 0 when outputs saturate
+const costEpsilon = 1e-12
+
+type quadraticCost struct{}
+
+func (quadraticCost) Name() string {
+    return "quadratic"
+}
+
+func (quadraticCost) Fn(a, y *mottuMat.MottuMat) float64 {
+    sum := 0.0
+    for i := 0; i < a.Rows(); i++ {
+        for j := 0; j < a.Cols(); j++ {
+            d := a.GetElem(i, j)-y.GetElem(i, j)
+            sum += d*d
+        }
+    }
+    return 0.5*sum
+}
+
+func (quadraticCost) Delta(z, a, y *mottuMat.MottuMat, act Activation) *mottuMat.MottuMat {
+    return act.Derivative(z, a, a.Sub(y))
+}
+
+type crossEntropyCost struct{}
+
+func (crossEntropyCost) Name() string {
+    return "cross_entropy"
+}
+
+func (crossEntropyCost) Fn(a, y *mottuMat.MottuMat) float64 {
+    sum := 0.0
+    for i := 0; i < a.Rows(); i++ {
+        for j := 0; j < a.Cols(); j++ {
+            av := clamp(a.GetElem(i, j), costEpsilon, 1-costEpsilon)
+            yv := y.GetElem(i, j)
+            sum -= yv*math.Log(av) + (1-yv)*math.Log(1-av)
+        }
+    }
+    return sum
+}
+
+func (crossEntropyCost) Delta(z, a, y *mottuMat.MottuMat, act Activation) *mottuMat.MottuMat {
+    if act == Sigmoid {
+        return a.Sub(y)
+    }
+    // partial C/partial a = (a-y)/(a(1-a))
+    dc_da := mottuMat.MakeMat(a.Rows(), a.Cols())
+    for i := 0; i < a.Rows(); i++ {
+        for j := 0; j < a.Cols(); j++ {
+            av := clamp(a.GetElem(i, j), costEpsilon, 1-costEpsilon)
+            dc_da.SetElem(i, j, (av-y.GetElem(i, j))/(av*(1-av)))
+        }
+    }
+    return act.Derivative(z, a, dc_da)
+}
+
+type logLikelihoodCost struct{}
+
+func (logLikelihoodCost) Name() string {
+    return "log_likelihood"
+}
+
+func (logLikelihoodCost) Fn(a, y *mottuMat.MottuMat) float64 {
+    sum := 0.0
+    for i := 0; i < a.Rows(); i++ {
+        for j := 0; j < a.Cols(); j++ {
+            if yv := y.GetElem(i, j); yv != 0 {
+                sum -= yv*math.Log(math.Max(a.GetElem(i, j), costEpsilon))
+            }
+        }
+    }
+    return sum
+}
+
+func (logLikelihoodCost) Delta(z, a, y *mottuMat.MottuMat, act Activation) *mottuMat.MottuMat {
+    if act == Softmax {
+        return a.Sub(y)
+    }
+    // partial C/partial a = -y/a
+    dc_da := mottuMat.MakeMat(a.Rows(), a.Cols())
+    for i := 0; i < a.Rows(); i++ {
+        for j := 0; j < a.Cols(); j++ {
+            dc_da.SetElem(i, j, -y.GetElem(i, j)/math.Max(a.GetElem(i, j), costEpsilon))
+        }
+    }
+    return act.Derivative(z, a, dc_da)
+}
+
+func clamp(x, lo, hi float64) float64 {
+    return math.Min(math.Max(x, lo), hi)
+}
@@ -0,0 +1,50 @@
+package network
+
+import (
+    "math"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Delta is the derivative of Fn(act(z), y) with respect to z, including the
+// shortcuts CrossEntropy takes for Sigmoid and LogLikelihood for Softmax
+func TestCostDelta(t *testing.T) {
+    const h = 1e-6
+    z := mottuMat.MakeMat(3, 2)
+    y := mottuMat.MakeMat(3, 2)
+    for i, v := range []float64{0.4, -1.2, 0.9, 2.1, -0.3, 0.05} {
+        z.SetElem(i/2, i%2, v)
+    }
+    // One-hot columns, which every cost takes
+    y.SetElem(1, 0, 1)
+    y.SetElem(2, 1, 1)
+
+    tests := []struct {
+        cost Cost
+        acts []Activation
+    }{
+        {Quadratic, []Activation{Sigmoid, Tanh, Identity, Softplus, GELU, Softmax}},
+        {CrossEntropy, []Activation{Sigmoid, Softmax}},
+        {LogLikelihood, []Activation{Softmax, Sigmoid, Softplus}},
+    }
+    for _, test := range tests {
+        for _, act := range test.acts {
+            delta := test.cost.Delta(z, act.Value(z), y, act)
+            for i := 0; i < z.Rows(); i++ {
+                for j := 0; j < z.Cols(); j++ {
+                    v := z.GetElem(i, j)
+                    z.SetElem(i, j, v+h)
+                    plus := test.cost.Fn(act.Value(z), y)
+                    z.SetElem(i, j, v-h)
+                    minus := test.cost.Fn(act.Value(z), y)
+                    z.SetElem(i, j, v)
+
+                    want := (plus - minus)/(2*h)
+                    if got := delta.GetElem(i, j); math.Abs(got - want) > 1e-6*math.Max(1, math.Abs(want)) {
+                        t.Errorf("%s with %s: delta (%d, %d) is %g, want %g", test.cost.Name(), act.Name(), i, j, got, want)
+                    }
+                }
+            }
+        }
+    }
+}
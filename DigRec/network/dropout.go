@@ -0,0 +1,117 @@
+package network
+
+import (
+    "fmt"
+    "math/rand"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Dropout is an (inverted) dropout layer. While training, every value is
+// kept with probability keep and the kept ones are scaled by 1/keep, so
+// FeedForward and Evaluate pass values through as is.
+type Dropout struct {
+    size int
+    keep float64
+}
+
+// MakeDropout returns a dropout layer for inputs of the given size
+func MakeDropout(size int, keep float64) *Dropout {
+    if keep <= 0 || keep > 1 {
+        panic(fmt.Sprintf("Dropout keep probability must be in (0, 1], got %g", keep))
+    }
+    return &Dropout{size, keep}
+}
+
+// Keep returns the probability of keeping a value
+func (d *Dropout) Keep() float64 {
+    return d.keep
+}
+
+func (d *Dropout) InputSize() int {
+    return d.size
+}
+
+func (d *Dropout) OutputSize() int {
+    return d.size
+}
+
+func (d *Dropout) Params() []Param {
+    return nil
+}
+
+func (d *Dropout) Grads() []*mottuMat.MottuMat {
+    return nil
+}
+
+// The cache is the mask the input was multiplied by, nil when nothing was
+// dropped. Dropped entries are 0, kept ones 1/keep.
+func (d *Dropout) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    if !train || d.keep >= 1 {
+        return x, nil
+    }
+    mask := mottuMat.MakeMat(x.Rows(), x.Cols())
+    for row := 0; row < mask.Rows(); row++ {
+        for col := 0; col < mask.Cols(); col++ {
+            if r.Float64() < d.keep {
+                mask.SetElem(row, col, 1/d.keep)
+            }
+        }
+    }
+    return x.HadMul(mask), mask
+}
+
+func (d *Dropout) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    mask, _ := cache.(*mottuMat.MottuMat)
+    if mask == nil {
+        return delta, nil
+    }
+    return delta.HadMul(mask), nil
+}
+
+// SetDropout turns on dropout for a hidden layer of a network made by
+// MakeMottuNet. layer indexes sizes, so it must be between 1 and
+// len(sizes)-2. A Dropout layer goes right after the activation of that
+// layer; a keep of 1 takes it out again.
+func (this *mottuNet) SetDropout(layer int, keep float64) {
+    if keep <= 0 || keep > 1 {
+        panic(fmt.Sprintf("Dropout keep probability must be in (0, 1], got %g", keep))
+    }
+    layers := this.model.layers
+    pos, dense := -1, 0
+    for i, l := range layers {
+        if _, ok := l.(*Dense); ok {
+            dense++
+            if dense == layer {
+                pos = i
+            }
+        }
+    }
+    if layer < 1 || layer > dense-1 {
+        panic(fmt.Sprintf("Dropout only applies to hidden layers, not layer %d", layer))
+    }
+    // Skip past the batch norm and activation of the layer
+    pos++
+    if _, ok := layers[pos].(*BatchNorm); ok {
+        pos++
+    }
+    if _, ok := layers[pos].(*ActivationLayer); ok {
+        pos++
+    }
+
+    if d, ok := layers[pos].(*Dropout); ok {
+        if keep < 1 {
+            d.keep = keep
+        } else {
+            layers = append(layers[:pos], layers[pos+1:]...)
+        }
+    } else if keep < 1 {
+        layers = append(layers[:pos], append([]Layer{MakeDropout(layers[pos].InputSize(), keep)}, layers[pos:]...)...)
+    }
+    this.model = MakeSequential(layers...)
+}
+
+// SetRand sets the source of the random choices made during training, such
+// as the order the training samples are visited in and dropout masks
+func (this *mottuNet) SetRand(r *rand.Rand) {
+    this.rng = r
+}
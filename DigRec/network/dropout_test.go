@@ -0,0 +1,87 @@
+package network
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Outside of training, and with a keep of 1, dropout passes values through
+func TestDropoutInference(t *testing.T) {
+    r := rand.New(rand.NewSource(1))
+    x := mottuMat.MakeMat(20, 5)
+    x.RandomizeWith(r)
+    for _, test := range []struct {
+        keep float64
+        train bool
+    }{{0.5, false}, {0.1, false}, {1, true}} {
+        out, cache := MakeDropout(20, test.keep).Forward(x, test.train, r)
+        if out.Sub(x).SumAbs() != 0 || cache != nil {
+            t.Errorf("keep %g train %v changed the input", test.keep, test.train)
+        }
+    }
+
+    // A network predicts the same with dropout as without
+    in := mottuMat.MakeMat(6, 5)
+    in.RandomizeWith(r)
+    mn := MakeMottuNet([]int{6, 8, 3})
+    want := mn.FeedForward(in)
+    mn.SetDropout(1, 0.3)
+    if d := mn.FeedForward(in).Sub(want).SumAbs(); d != 0 {
+        t.Errorf("dropout changed the output by %g", d)
+    }
+}
+
+// While training every value is kept with probability keep and scaled by
+// 1/keep, so the expected output is the input, and Backward applies the
+// same mask to the gradient
+func TestDropoutMask(t *testing.T) {
+    const keep = 0.8
+    r := rand.New(rand.NewSource(2))
+    x := mottuMat.MakeMat(100, 100)
+    x.RandomizeUniform(r, 1, 2)
+    d := MakeDropout(100, keep)
+    out, cache := d.Forward(x, true, r)
+    delta := mottuMat.MakeMat(100, 100)
+    delta.RandomizeWith(r)
+    back, grads := d.Backward(cache, delta)
+    if grads != nil {
+        t.Errorf("dropout has gradients %v", grads)
+    }
+
+    kept, sum_in, sum_out := 0, 0.0, 0.0
+    for i := 0; i < x.Rows(); i++ {
+        for j := 0; j < x.Cols(); j++ {
+            v, o, b := x.GetElem(i, j), out.GetElem(i, j), back.GetElem(i, j)
+            sum_in += v
+            sum_out += o
+            switch {
+            case o == 0 && b == 0:
+            case math.Abs(o - v/keep) < 1e-12 && math.Abs(b - delta.GetElem(i, j)/keep) < 1e-12:
+                kept++
+            default:
+                t.Fatalf("(%d, %d): %g became %g and its gradient %g became %g", i, j, v, o, delta.GetElem(i, j), b)
+            }
+        }
+    }
+    // 10000 draws put 4 standard deviations of the kept fraction at 0.016
+    if frac := float64(kept)/10000; math.Abs(frac - keep) > 0.016 {
+        t.Errorf("kept %g of the values, want %g", frac, keep)
+    }
+    if math.Abs(sum_out/sum_in - 1) > 0.02 {
+        t.Errorf("dropout scaled the sum of the values by %g", sum_out/sum_in)
+    }
+}
+
+// The same source draws the same mask
+func TestDropoutSeed(t *testing.T) {
+    x := mottuMat.MakeMat(10, 10)
+    x.RandomizeWith(rand.New(rand.NewSource(1)))
+    d := MakeDropout(10, 0.5)
+    a, _ := d.Forward(x, true, rand.New(rand.NewSource(3)))
+    b, _ := d.Forward(x, true, rand.New(rand.NewSource(3)))
+    if a.Sub(b).SumAbs() != 0 {
+        t.Error("the same seed dropped different values")
+    }
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Monitor picks the validation metric that early stopping watches
+type Monitor int
+
+const (
+    MonitorAccuracy Monitor = iota // higher is better
+    MonitorLoss                    // lower is better
+)
+
+// EarlyStopping ends SGD once the monitored validation metric stops
+// improving, and puts back the weights and biases from the best epoch
+type EarlyStopping struct {
+    Monitor Monitor
+    // Number of epochs in a row without improvement before stopping
+    Patience int
+    // Smallest change in the metric that counts as an improvement
+    MinDelta float64
+}
+
+// SetEarlyStopping turns on early stopping, or turns it off when es is nil.
+// It has no effect unless a validation set was given to SetValidationData.
+func (this *mottuNet) SetEarlyStopping(es *EarlyStopping) {
+    if es == nil {
+        this.early_stopping = nil
+        return
+    }
+    config := *es
+    this.early_stopping = &config
+}
+
+// early_stop_tracker follows the monitored metric during one SGD run
+type early_stop_tracker struct {
+    config EarlyStopping
+    best float64
+    best_epoch int
+    bad_epochs int
+    best_params []*mottuMat.MottuMat // copies of net.state_mats()
+}
+
+// update records the end of an epoch and says whether to stop
+func (t *early_stop_tracker) update(net *mottuNet, stats EpochStats) bool {
+    metric := stats.Accuracy
+    if t.config.Monitor == MonitorLoss {
+        metric = -stats.ValidationLoss
+    }
+    if t.best_params == nil || metric > t.best + t.config.MinDelta {
+        t.best = metric
+        t.best_epoch = stats.Epoch
+        t.bad_epochs = 0
+        t.snapshot(net)
+        return false
+    }
+    t.bad_epochs++
+    return t.bad_epochs >= t.config.Patience
+}
+
+func (t *early_stop_tracker) snapshot(net *mottuNet) {
+    mats := net.state_mats()
+    if t.best_params == nil {
+        t.best_params = make([]*mottuMat.MottuMat, len(mats))
+        for i, m := range mats {
+            t.best_params[i] = m.Copy()
+        }
+        return
+    }
+    for i, m := range mats {
+        t.best_params[i].CopyFrom(m)
+    }
+}
+
+// restore puts the best snapshot back into the network
+func (t *early_stop_tracker) restore(net *mottuNet) {
+    if t.best_params == nil {
+        return
+    }
+    for i, m := range net.state_mats() {
+        m.CopyFrom(t.best_params[i])
+    }
+}
@@ -0,0 +1,178 @@
+package network
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Evaluation is the detailed result of running a network over a data set.
+// Classes are the rows of the output layer, 10 for MNIST digits.
+type Evaluation struct {
+    Count int
+    NumCorrect int
+    Accuracy float64
+    // Loss is the average cost per sample, without regularization
+    Loss float64
+    // Confusion[expected][predicted] counts the samples of class expected
+    // the network put in class predicted
+    Confusion [][]int
+    Precision []float64 // per class, 0 when the class was never predicted
+    Recall []float64 // per class, 0 when the class never occurs
+    F1 []float64
+    // TopKAccuracy is the fraction of samples whose class is among the TopK
+    // highest outputs
+    TopK int
+    TopKAccuracy float64
+    // Misclassified holds the indices into the data set of the samples the
+    // network got wrong, in order
+    Misclassified []int
+}
+
+// EvaluateDetailed runs the network over every sample of test_data. Top-k
+// accuracy is measured for the given k. It fails if k is out of range or a
+// sample doesn't fit the input or output layer.
+func (this *mottuNet) EvaluateDetailed(test_data *mnist.Set, k int) (*Evaluation, error) {
+    classes := this.model.OutputSize()
+    if k < 1 || k > classes {
+        return nil, fmt.Errorf("network: top-k needs 1 <= k <= %d, got %d", classes, k)
+    }
+    for i := 0; i < test_data.Count(); i++ {
+        image, exp_out := test_data.Get(i)
+        if image.Rows() != this.InputSize() || image.Cols() != 1 {
+            return nil, fmt.Errorf("network: sample %d: %w", i, &mottuMat.ShapeError{
+                Op: "EvaluateDetailed",
+                A: mottuMat.Shape{Rows: this.InputSize(), Cols: 1},
+                B: image.Shape(),
+            })
+        }
+        if exp_out.Rows() != classes || exp_out.Cols() != 1 {
+            return nil, fmt.Errorf("network: expected output %d: %w", i, &mottuMat.ShapeError{
+                Op: "EvaluateDetailed",
+                A: mottuMat.Shape{Rows: classes, Cols: 1},
+                B: exp_out.Shape(),
+            })
+        }
+    }
+    e := &Evaluation{
+        Count: test_data.Count(),
+        Confusion: make([][]int, classes),
+        Precision: make([]float64, classes),
+        Recall: make([]float64, classes),
+        F1: make([]float64, classes),
+        TopK: k,
+    }
+    for i := range e.Confusion {
+        e.Confusion[i] = make([]int, classes)
+    }
+
+    top_k_correct := 0
+    for i := 0; i < test_data.Count(); i++ {
+        image, exp_out := test_data.Get(i)
+        out := this.FeedForward(image)
+        e.Loss += this.cost.Fn(out, exp_out)
+
+        top := top_k(out, k)
+        expected := argmax(exp_out)
+        e.Confusion[expected][top[0]]++
+        if top[0] == expected {
+            e.NumCorrect++
+        } else {
+            e.Misclassified = append(e.Misclassified, i)
+        }
+        for _, c := range top {
+            if c == expected {
+                top_k_correct++
+                break
+            }
+        }
+    }
+    if e.Count == 0 {
+        return e, nil
+    }
+    e.Loss /= float64(e.Count)
+    e.Accuracy = float64(e.NumCorrect)/float64(e.Count)
+    e.TopKAccuracy = float64(top_k_correct)/float64(e.Count)
+
+    for c := 0; c < classes; c++ {
+        predicted, actual := 0, 0
+        for o := 0; o < classes; o++ {
+            predicted += e.Confusion[o][c]
+            actual += e.Confusion[c][o]
+        }
+        tp := float64(e.Confusion[c][c])
+        if predicted > 0 {
+            e.Precision[c] = tp/float64(predicted)
+        }
+        if actual > 0 {
+            e.Recall[c] = tp/float64(actual)
+        }
+        if e.Precision[c]+e.Recall[c] > 0 {
+            e.F1[c] = 2*e.Precision[c]*e.Recall[c]/(e.Precision[c]+e.Recall[c])
+        }
+    }
+    return e, nil
+}
+
+// MacroF1 returns the F1 score averaged over the classes
+func (e *Evaluation) MacroF1() float64 {
+    if len(e.F1) == 0 {
+        return 0
+    }
+    sum := 0.0
+    for _, f := range e.F1 {
+        sum += f
+    }
+    return sum/float64(len(e.F1))
+}
+
+// String lays out the confusion matrix, expected classes down the side and
+// predicted ones across the top, followed by the per class metrics
+func (e *Evaluation) String() string {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "accuracy %d / %d (%.2f%%), top-%d %.2f%%, loss %.4f\n",
+                e.NumCorrect, e.Count, 100*e.Accuracy, e.TopK, 100*e.TopKAccuracy, e.Loss)
+    sb.WriteString("     ")
+    for c := range e.Confusion {
+        fmt.Fprintf(&sb, "%6d", c)
+    }
+    sb.WriteString("\n")
+    for c, row := range e.Confusion {
+        fmt.Fprintf(&sb, "%4d ", c)
+        for _, n := range row {
+            fmt.Fprintf(&sb, "%6d", n)
+        }
+        sb.WriteString("\n")
+    }
+    sb.WriteString("class precision recall     f1\n")
+    for c := range e.Confusion {
+        fmt.Fprintf(&sb, "%5d %9.4f %6.4f %6.4f\n", c, e.Precision[c], e.Recall[c], e.F1[c])
+    }
+    return sb.String()
+}
+
+// argmax returns the row of the largest entry of the col vec v
+func argmax(v *mottuMat.MottuMat) int {
+    best := 0
+    for i := 1; i < v.Rows(); i++ {
+        if v.GetElem(i, 0) > v.GetElem(best, 0) {
+            best = i
+        }
+    }
+    return best
+}
+
+// top_k returns the rows of the k largest entries of the col vec v, largest
+// first. Ties go to the lower row.
+func top_k(v *mottuMat.MottuMat, k int) []int {
+    rows := make([]int, v.Rows())
+    for i := range rows {
+        rows[i] = i
+    }
+    sort.SliceStable(rows, func(a, b int) bool {
+        return v.GetElem(rows[a], 0) > v.GetElem(rows[b], 0)
+    })
+    return rows[:k]
+}
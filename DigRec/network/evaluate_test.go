@@ -0,0 +1,62 @@
+package network
+
+import (
+    "errors"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// An identity output layer with all negative outputs still predicts the
+// largest of them
+func TestEvaluateNegativeOutputs(t *testing.T) {
+    d := MakeDense(2, 3)
+    for i := 0; i < 3; i++ {
+        d.Biases().SetElem(i, 0, -float64(i+1))
+    }
+    mn := MakeMottuNetFromLayers(d, MakeActivationLayer(3, Identity))
+    set := &mnist.Set{NRow: 1, NCol: 2}
+    for label := 0; label < 3; label++ {
+        y := mottuMat.MakeColVec(3)
+        y.SetElem(label, 0, 1)
+        set.Images = append(set.Images, mottuMat.MakeColVec(2))
+        set.ExpOut = append(set.ExpOut, y)
+    }
+    if n := mn.Evaluate(set); n != 1 {
+        t.Errorf("Evaluate counts %d correct, want 1", n)
+    }
+}
+
+func TestEvaluateDetailedErrors(t *testing.T) {
+    mn := MakeMottuNet([]int{2, 3})
+    set := &mnist.Set{NRow: 1, NCol: 2}
+    set.Images = append(set.Images, mottuMat.MakeColVec(2))
+    set.ExpOut = append(set.ExpOut, mottuMat.MakeColVec(3))
+    e, err := mn.EvaluateDetailed(set, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if e.Count != 1 || e.TopK != 2 {
+        t.Errorf("got count %d and top-%d, want 1 and top-2", e.Count, e.TopK)
+    }
+
+    for _, k := range []int{0, 4} {
+        if _, err := mn.EvaluateDetailed(set, k); err == nil {
+            t.Errorf("k = %d: no error", k)
+        }
+    }
+
+    // Labels for 10 classes against a network with 3 outputs
+    set.ExpOut[0] = mottuMat.MakeColVec(10)
+    _, err = mn.EvaluateDetailed(set, 1)
+    var shape_err *mottuMat.ShapeError
+    if !errors.As(err, &shape_err) {
+        t.Errorf("wrong label size: got %v, want a *mottuMat.ShapeError", err)
+    }
+
+    set.ExpOut[0] = mottuMat.MakeColVec(3)
+    set.Images[0] = mottuMat.MakeColVec(5)
+    if _, err := mn.EvaluateDetailed(set, 1); !errors.As(err, &shape_err) {
+        t.Errorf("wrong image size: got %v, want a *mottuMat.ShapeError", err)
+    }
+}
@@ -0,0 +1,119 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// DefaultGradCheckEpsilon is the step GradCheck takes in each direction
+const DefaultGradCheckEpsilon = 1e-5
+
+// GradCheckResult is how far backprop is from finite differences for the
+// parameters of one layer
+type GradCheckResult struct {
+    Layer int // index into Model().Layers()
+    Type string // type of the layer, e.g. *network.Dense
+    Checked int // number of parameters compared
+    MaxRelError float64
+    MaxAbsError float64
+}
+
+func (r GradCheckResult) String() string {
+    return fmt.Sprintf("layer %d (%s): %d params, max relative error %.3g, max absolute error %.3g",
+                       r.Layer, r.Type, r.Checked, r.MaxRelError, r.MaxAbsError)
+}
+
+// GradCheck compares the gradients backprop finds for the batch x with
+// expected outputs y against central differences: every weight and bias is
+// moved by +-epsilon in turn and the cost measured on the same batch. There
+// is a result for every layer with parameters. The relative error of a
+// parameter is its absolute error over the larger of the two gradients, 0
+// when both are 0.
+//
+// The gradients are of the cost alone, without regularization. Dropout
+// masks are drawn from the same seed for every pass, so they don't change
+// between the two sides. This takes two forward passes per parameter, so keep
+// the network and batch small.
+func (this *mottuNet) GradCheck(x, y *mottuMat.MottuMat, epsilon float64) []GradCheckResult {
+    results, _ := this.grad_check(x, y, epsilon, nil)
+    return results
+}
+
+// CheckGradients runs GradCheck and returns an error naming the first layer
+// with a parameter whose numeric and analytic gradients differ by more than
+// atol and by more than rtol times the larger of the two. The absolute bound
+// covers gradients that are 0 or nearly so, where the relative error is
+// meaningless. E.g. for use in tests:
+//
+//    if err := net.CheckGradients(x, y, network.DefaultGradCheckEpsilon, 1e-7, 1e-5); err != nil {
+//        t.Fatal(err)
+//    }
+func (this *mottuNet) CheckGradients(x, y *mottuMat.MottuMat, epsilon, atol, rtol float64) error {
+    _, err := this.grad_check(x, y, epsilon, func(numeric, analytic float64) bool {
+        abs_err := math.Abs(numeric-analytic)
+        return abs_err <= atol || abs_err <= rtol*math.Max(math.Abs(numeric), math.Abs(analytic))
+    })
+    return err
+}
+
+// grad_check does the work of GradCheck. If ok isn't nil it is asked about
+// every parameter, and the error names the first layer it rejects one of.
+func (this *mottuNet) grad_check(x, y *mottuMat.MottuMat, epsilon float64,
+                                 ok func(numeric, analytic float64) bool) ([]GradCheckResult, error) {
+    const seed = DefaultSeed
+    nabla, _, _ := this.backprop(x, y, rand.New(rand.NewSource(seed)))
+
+    var results []GradCheckResult
+    var err error
+    k := 0 // index into nabla
+    for i, l := range this.model.layers {
+        params := l.Params()
+        if len(params) == 0 {
+            continue
+        }
+        result := GradCheckResult{Layer: i, Type: fmt.Sprintf("%T", l)}
+        failed := 0
+        for _, p := range params {
+            grad := nabla[k]
+            k++
+            for row := 0; row < p.Value.Rows(); row++ {
+                for col := 0; col < p.Value.Cols(); col++ {
+                    v := p.Value.GetElem(row, col)
+                    p.Value.SetElem(row, col, v+epsilon)
+                    cost_plus := this.train_cost(x, y, rand.New(rand.NewSource(seed)))
+                    p.Value.SetElem(row, col, v-epsilon)
+                    cost_minus := this.train_cost(x, y, rand.New(rand.NewSource(seed)))
+                    p.Value.SetElem(row, col, v)
+
+                    numeric := (cost_plus-cost_minus)/(2*epsilon)
+                    analytic := grad.GetElem(row, col)
+                    abs_err := math.Abs(numeric-analytic)
+                    rel_err := 0.0
+                    if abs_err > 0 {
+                        rel_err = abs_err/math.Max(math.Abs(numeric), math.Abs(analytic))
+                    }
+                    result.MaxAbsError = math.Max(result.MaxAbsError, abs_err)
+                    result.MaxRelError = math.Max(result.MaxRelError, rel_err)
+                    result.Checked++
+                    if ok != nil && !ok(numeric, analytic) {
+                        failed++
+                    }
+                }
+            }
+        }
+        if failed > 0 && err == nil {
+            err = fmt.Errorf("network: gradient check failed for %d params of %v", failed, result)
+        }
+        results = append(results, result)
+    }
+    return results, err
+}
+
+// train_cost is the cost backprop sees for the batch x: the layers run in
+// training mode and nothing is regularized
+func (this *mottuNet) train_cost(x, y *mottuMat.MottuMat, r *rand.Rand) float64 {
+    a, _ := this.model.Forward(x, true, r)
+    return this.cost.Fn(a, y)
+}
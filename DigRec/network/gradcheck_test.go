@@ -0,0 +1,87 @@
+package network
+
+import (
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// grad_batch returns a batch of n random inputs and one-hot expected outputs
+func grad_batch(n, in, out int, r *rand.Rand) (*mottuMat.MottuMat, *mottuMat.MottuMat) {
+    x, y := mottuMat.MakeMat(in, n), mottuMat.MakeMat(out, n)
+    x.RandomizeUniform(r, -1, 1)
+    for j := 0; j < n; j++ {
+        y.SetElem(r.Intn(out), j, 1)
+    }
+    return x, y
+}
+
+func TestCheckGradients(t *testing.T) {
+    r := rand.New(rand.NewSource(5))
+    image := Volume{1, 6, 6}
+    conv := MakeConv2D(image, 2, 3, 1, 1, Tanh, r)
+    pool := MakeMaxPool(conv.OutputVolume(), 2, 2)
+
+    tests := []struct {
+        name string
+        net *mottuNet
+        cost Cost
+    }{
+        {"dense sigmoid quadratic", MakeMottuNetWithActivations([]int{4, 5, 3}, []Activation{Sigmoid, Sigmoid}), Quadratic},
+        {"softmax log-likelihood", MakeMottuNetWithActivations([]int{4, 5, 3}, []Activation{Tanh, Softmax}), LogLikelihood},
+        {"conv pool", MakeMottuNetFromLayers(conv, pool, MakeFlatten(pool.OutputVolume()),
+                                             MakeDense(pool.OutputSize(), 3), MakeActivationLayer(3, Sigmoid)), CrossEntropy},
+        {"batchnorm", MakeMottuNetFromLayers(MakeDense(4, 5), MakeBatchNorm(5, 0.9), MakeActivationLayer(5, Tanh),
+                                             MakeDense(5, 3), MakeActivationLayer(3, Sigmoid)), Quadratic},
+        // The mask is drawn from the same seed on every pass, so it stays fixed
+        {"dropout", MakeMottuNetFromLayers(MakeDense(4, 5), MakeActivationLayer(5, Tanh), MakeDropout(5, 0.5),
+                                           MakeDense(5, 3), MakeActivationLayer(3, Sigmoid)), Quadratic},
+    }
+    for _, test := range tests {
+        test.net.Initialize(ScaledNormal, r)
+        test.net.SetCost(test.cost)
+        x, y := grad_batch(4, test.net.InputSize(), test.net.model.OutputSize(), r)
+        if err := test.net.CheckGradients(x, y, DefaultGradCheckEpsilon, 1e-8, 1e-5); err != nil {
+            t.Errorf("%s: %v", test.name, err)
+        }
+    }
+}
+
+// Parameters whose gradient is 0 or nearly so have to pass even though the
+// numeric estimate is only that close up to rounding
+func TestCheckGradientsZero(t *testing.T) {
+    mn := MakeMottuNetWithActivations([]int{3, 2}, []Activation{Sigmoid})
+    mn.Initialize(ScaledNormal, rand.New(rand.NewSource(1)))
+    // The first input is tiny in every sample, so its weights get next to no
+    // gradient and the relative error of the estimate is large
+    x, y := grad_batch(3, 3, 2, rand.New(rand.NewSource(2)))
+    for j := 0; j < x.Cols(); j++ {
+        x.SetElem(0, j, 1e-12)
+    }
+    if err := mn.CheckGradients(x, y, DefaultGradCheckEpsilon, 1e-8, 1e-5); err != nil {
+        t.Fatal(err)
+    }
+    for _, result := range mn.GradCheck(x, y, DefaultGradCheckEpsilon) {
+        if result.Checked != 8 {
+            t.Errorf("checked %d params, want 8", result.Checked)
+        }
+        if result.MaxRelError < 1e-5 {
+            t.Errorf("max relative error %g, the test needs one the absolute tolerance has to cover", result.MaxRelError)
+        }
+    }
+
+    // A wrong gradient still fails
+    mn.SetCost(wrongCost{Quadratic})
+    if err := mn.CheckGradients(x, y, DefaultGradCheckEpsilon, 1e-8, 1e-5); err == nil {
+        t.Error("a wrong gradient passed the check")
+    }
+}
+
+// wrongCost halves the delta of the cost it wraps
+type wrongCost struct {
+    Cost
+}
+
+func (c wrongCost) Delta(z, a, y *mottuMat.MottuMat, act Activation) *mottuMat.MottuMat {
+    return c.Cost.Delta(z, a, y, act).Scale(0.5)
+}
@@ -0,0 +1,56 @@
+package network
+
+import (
+    "NeuralNetworks/DigRec/mnist"
+    "time"
+)
+
+// EpochStats is what SGD knows about an epoch once it is done
+type EpochStats struct {
+    Epoch int
+    Loss float64 // average training cost over the epoch's mini batches
+    Eta float64 // learning rate of the epoch's last mini batch
+    Elapsed time.Duration // since SGD started
+    EpochTime time.Duration // spent on this epoch alone
+
+    // Only filled in when a validation set was given to SetValidationData
+    Validated bool
+    NumCorrect int
+    Accuracy float64 // NumCorrect / number of validation samples
+    // Average cost on the validation set, with regularization scaled by the
+    // size of the training set like Loss. Only computed when there are hooks
+    // or early stopping monitors the loss, 0 otherwise.
+    ValidationLoss float64
+
+    // Set on the last epoch when early stopping ends training
+    EarlyStopped bool
+}
+
+// Hooks lets callers follow along while SGD trains. Embed BaseHooks to only
+// implement some of the methods.
+type Hooks interface {
+    OnEpochStart(epoch int)
+    // loss is the average cost of the samples seen so far in the epoch
+    OnBatchEnd(epoch, batch int, loss float64)
+    // Returning true stops training, e.g. when the loss is diverging
+    OnEpochEnd(stats EpochStats) bool
+}
+
+// BaseHooks does nothing
+type BaseHooks struct{}
+
+func (BaseHooks) OnEpochStart(epoch int) {}
+func (BaseHooks) OnBatchEnd(epoch, batch int, loss float64) {}
+func (BaseHooks) OnEpochEnd(stats EpochStats) bool { return false }
+
+// SetHooks installs the hooks called by SGD. With no hooks SGD just prints
+// the epoch number.
+func (this *mottuNet) SetHooks(h Hooks) {
+    this.hooks = h
+}
+
+// SetValidationData sets a data set that SGD evaluates the network on after
+// every epoch. Pass nil to turn it off.
+func (this *mottuNet) SetValidationData(data *mnist.Set) {
+    this.validation_data = data
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "testing"
+)
+
+// event_hooks logs every call SGD makes, stopping after stop_after epochs
+// when that is positive
+type event_hooks struct {
+    events []string
+    batch_losses []float64
+    stats []EpochStats
+    stop_after int
+}
+
+func (h *event_hooks) OnEpochStart(epoch int) {
+    h.events = append(h.events, fmt.Sprint("start ", epoch))
+}
+
+func (h *event_hooks) OnBatchEnd(epoch, batch int, loss float64) {
+    h.events = append(h.events, fmt.Sprint("batch ", epoch, " ", batch))
+    h.batch_losses = append(h.batch_losses, loss)
+}
+
+func (h *event_hooks) OnEpochEnd(stats EpochStats) bool {
+    h.events = append(h.events, fmt.Sprint("end ", stats.Epoch))
+    h.stats = append(h.stats, stats)
+    return len(h.stats) == h.stop_after
+}
+
+func TestHooksOrder(t *testing.T) {
+    train, validation := test_set(30, 4, 2, 1), test_set(10, 4, 2, 2)
+    mn := MakeMottuNet([]int{4, 3, 2})
+    mn.SetRegularization(0, 0.1)
+    mn.SetSchedule(MakeStepDecay(0.5, 1))
+    mn.SetValidationData(validation)
+    hooks := &event_hooks{}
+    mn.SetHooks(hooks)
+    mn.SGD(train, 2, 10, 0.4)
+
+    want := []string{
+        "start 0", "batch 0 0", "batch 0 1", "batch 0 2", "end 0",
+        "start 1", "batch 1 0", "batch 1 1", "batch 1 2", "end 1",
+    }
+    if fmt.Sprint(hooks.events) != fmt.Sprint(want) {
+        t.Fatalf("hooks called as %v, want %v", hooks.events, want)
+    }
+    for j, stats := range hooks.stats {
+        if stats.Epoch != j {
+            t.Errorf("stats of epoch %d say epoch %d", j, stats.Epoch)
+        }
+        if want := 0.4*math.Pow(0.5, float64(j)); math.Abs(stats.Eta - want) > 1e-15 {
+            t.Errorf("epoch %d: eta %g, want %g", j, stats.Eta, want)
+        }
+        // The epoch's loss is the running loss after its last batch
+        if want := hooks.batch_losses[3*j+2]; stats.Loss != want {
+            t.Errorf("epoch %d: loss %g, last batch said %g", j, stats.Loss, want)
+        }
+        if !stats.Validated || stats.Accuracy != float64(stats.NumCorrect)/10 {
+            t.Errorf("epoch %d: validated %v, %d correct, accuracy %g", j, stats.Validated, stats.NumCorrect, stats.Accuracy)
+        }
+        if stats.EpochTime <= 0 || stats.Elapsed < stats.EpochTime {
+            t.Errorf("epoch %d: took %v of %v", j, stats.EpochTime, stats.Elapsed)
+        }
+        if stats.EarlyStopped {
+            t.Errorf("epoch %d stopped early", j)
+        }
+    }
+    if last := hooks.stats[1]; last.NumCorrect != mn.Evaluate(validation) || last.Elapsed < hooks.stats[0].Elapsed {
+        t.Errorf("last epoch: %d correct, Evaluate %d", last.NumCorrect, mn.Evaluate(validation))
+    }
+}
+
+// Returning true from OnEpochEnd ends training there
+func TestHooksStop(t *testing.T) {
+    mn := MakeMottuNet([]int{4, 3, 2})
+    hooks := &event_hooks{stop_after: 2}
+    mn.SetHooks(hooks)
+    mn.SGD(test_set(20, 4, 2, 1), 5, 10, 0.4)
+    want := []string{"start 0", "batch 0 0", "batch 0 1", "end 0", "start 1", "batch 1 0", "batch 1 1", "end 1"}
+    if fmt.Sprint(hooks.events) != fmt.Sprint(want) {
+        t.Errorf("hooks called as %v, want %v", hooks.events, want)
+    }
+    if hooks.stats[0].Validated {
+        t.Error("epochs are validated without validation data")
+    }
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "strconv"
+    "strings"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// DefaultSeed seeds the random initialization done by MakeMottuNet
+const DefaultSeed = 1
+
+// Initializer picks the starting weights and biases of a layer
+type Initializer interface {
+    // InitWeights fills the weights w of a layer with fan_in inputs and
+    // fan_out neurons, drawing from r
+    InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand)
+    // InitBiases fills the biases b of a layer, drawing from r
+    InitBiases(b *mottuMat.MottuMat, r *rand.Rand)
+}
+
+var (
+    // StandardNormal draws weights and biases from N(0, 1). With many inputs
+    // the weighted input z gets large and sigmoid neurons start saturated.
+    StandardNormal Initializer = standardNormal{}
+    // ScaledNormal draws weights from N(0, 1/fan_in) and biases from N(0, 1),
+    // which keeps z around N(0, 1) at the start.
+    ScaledNormal Initializer = scaledNormal{}
+    // Xavier (Glorot) draws weights uniformly from +-sqrt(6/(fan_in+fan_out))
+    // and zeroes the biases. Suited to tanh and sigmoid layers.
+    Xavier Initializer = xavier{}
+    // He (Kaiming) draws weights from N(0, 2/fan_in) and zeroes the biases.
+    // Suited to ReLU layers.
+    He Initializer = he{}
+)
+
+// Uniform draws weights and biases uniformly from [Low, High)
+type Uniform struct {
+    Low float64
+    High float64
+}
+
+func (u Uniform) InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand) {
+    w.RandomizeUniform(r, u.Low, u.High)
+}
+
+func (u Uniform) InitBiases(b *mottuMat.MottuMat, r *rand.Rand) {
+    b.RandomizeUniform(r, u.Low, u.High)
+}
+
+type standardNormal struct{}
+
+func (standardNormal) InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand) {
+    w.RandomizeWith(r)
+}
+
+func (standardNormal) InitBiases(b *mottuMat.MottuMat, r *rand.Rand) {
+    b.RandomizeWith(r)
+}
+
+type scaledNormal struct{}
+
+func (scaledNormal) InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand) {
+    w.RandomizeNormal(r, 0, 1/math.Sqrt(float64(fan_in)))
+}
+
+func (scaledNormal) InitBiases(b *mottuMat.MottuMat, r *rand.Rand) {
+    b.RandomizeWith(r)
+}
+
+type xavier struct{}
+
+func (xavier) InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand) {
+    limit := math.Sqrt(6/float64(fan_in+fan_out))
+    w.RandomizeUniform(r, -limit, limit)
+}
+
+func (xavier) InitBiases(b *mottuMat.MottuMat, r *rand.Rand) {
+    b.Zero()
+}
+
+type he struct{}
+
+func (he) InitWeights(w *mottuMat.MottuMat, fan_in, fan_out int, r *rand.Rand) {
+    w.RandomizeNormal(r, 0, math.Sqrt(2/float64(fan_in)))
+}
+
+func (he) InitBiases(b *mottuMat.MottuMat, r *rand.Rand) {
+    b.Zero()
+}
+
+// ParseInitializer returns the initializer with the given name: "normal",
+// "scaled_normal", "xavier", "he" or "uniform:a" for Uniform{-a, a}
+func ParseInitializer(name string) (Initializer, error) {
+    base, param, has_param := strings.Cut(strings.ToLower(strings.TrimSpace(name)), ":")
+    switch base {
+    case "normal", "standard_normal":
+        return StandardNormal, nil
+    case "scaled_normal", "scaled":
+        return ScaledNormal, nil
+    case "xavier", "glorot":
+        return Xavier, nil
+    case "he", "kaiming":
+        return He, nil
+    case "uniform":
+        limit := 0.05
+        if has_param {
+            var err error
+            if limit, err = strconv.ParseFloat(param, 64); err != nil {
+                return nil, fmt.Errorf("network: bad parameter for initializer %q: %w", name, err)
+            }
+        }
+        return Uniform{-limit, limit}, nil
+    }
+    return nil, fmt.Errorf("network: unknown initializer %q", name)
+}
+
+// Initialize redraws the weights and biases of every layer that has an
+// Initialize method. All layers draw from r in order, so a fixed seed gives
+// the same network every time while each layer still gets its own values.
+func (this *mottuNet) Initialize(init Initializer, r *rand.Rand) {
+    for _, l := range this.model.layers {
+        if li, ok := l.(interface{ Initialize(Initializer, *rand.Rand) }); ok {
+            li.Initialize(init, r)
+        }
+    }
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// moments returns the mean, variance and largest magnitude of the entries
+// of m
+func moments(m *mottuMat.MottuMat) (float64, float64, float64) {
+    n := float64(m.Rows()*m.Cols())
+    sum, sum_sq, max_abs := 0.0, 0.0, 0.0
+    for i := 0; i < m.Rows(); i++ {
+        for j := 0; j < m.Cols(); j++ {
+            v := m.GetElem(i, j)
+            sum += v
+            sum_sq += v*v
+            max_abs = math.Max(max_abs, math.Abs(v))
+        }
+    }
+    mean := sum/n
+    return mean, sum_sq/n - mean*mean, max_abs
+}
+
+// Every scheme draws weights with mean 0 and the variance it promises for
+// 300 inputs and 200 outputs, and biases as documented
+func TestInitializerScale(t *testing.T) {
+    const fan_in, fan_out = 300, 200
+    xavier_limit := math.Sqrt(6.0/(fan_in+fan_out))
+    tests := []struct {
+        name string
+        init Initializer
+        weight_var float64
+        limit float64 // bound on the weights for uniform draws, 0 otherwise
+        bias_var float64
+    }{
+        {"normal", StandardNormal, 1, 0, 1},
+        {"scaled_normal", ScaledNormal, 1.0/fan_in, 0, 1},
+        {"xavier", Xavier, 2.0/(fan_in+fan_out), xavier_limit, 0},
+        {"he", He, 2.0/fan_in, 0, 0},
+        {"uniform", Uniform{-0.3, 0.3}, 0.09/3, 0.3, 0.09/3},
+    }
+    for _, test := range tests {
+        r := rand.New(rand.NewSource(1))
+        w := mottuMat.MakeMat(fan_out, fan_in)
+        b := mottuMat.MakeColVec(10000)
+        test.init.InitWeights(w, fan_in, fan_out, r)
+        test.init.InitBiases(b, r)
+
+        mean, variance, max_abs := moments(w)
+        if math.Abs(mean) > 0.02*math.Sqrt(test.weight_var) {
+            t.Errorf("%s: weight mean %g", test.name, mean)
+        }
+        if math.Abs(variance/test.weight_var - 1) > 0.02 {
+            t.Errorf("%s: weight variance %g, want %g", test.name, variance, test.weight_var)
+        }
+        if test.limit != 0 && max_abs > test.limit {
+            t.Errorf("%s: weight %g outside +-%g", test.name, max_abs, test.limit)
+        }
+
+        mean, variance, max_abs = moments(b)
+        if test.bias_var == 0 {
+            if max_abs != 0 {
+                t.Errorf("%s: biases should be zero, got one of %g", test.name, max_abs)
+            }
+        } else if math.Abs(mean) > 0.05*math.Sqrt(test.bias_var) || math.Abs(variance/test.bias_var - 1) > 0.05 {
+            t.Errorf("%s: bias mean %g variance %g, want 0 and %g", test.name, mean, variance, test.bias_var)
+        }
+    }
+}
+
+// The same seed initializes the same network, and every layer gets its own
+// draws
+func TestInitializeSeed(t *testing.T) {
+    var nets [2]*mottuNet
+    for i := range nets {
+        nets[i] = MakeMottuNet([]int{5, 5, 5})
+        nets[i].Initialize(He, rand.New(rand.NewSource(3)))
+    }
+    if d := max_param_diff(nets[0], nets[1]); d != 0 {
+        t.Errorf("the same seed gave params %g apart", d)
+    }
+    params := nets[0].params()
+    if params[0].Value.Sub(params[2].Value).SumAbs() == 0 {
+        t.Error("both layers got the same weights")
+    }
+}
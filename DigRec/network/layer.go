@@ -0,0 +1,243 @@
+package network
+
+import (
+    "fmt"
+    "math/rand"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Layer is one step of a Sequential model. Matrices hold one sample per
+// column. Layers keep no state between Forward and Backward, and Backward
+// returns the gradients of its pass rather than storing them, so several
+// goroutines can backpropagate through the same layer at once. The gradients
+// of a whole mini batch are stored on the layer once they are summed, for
+// the optimizer to step with and for Grads to return.
+type Layer interface {
+    InputSize() int
+    OutputSize() int
+    // Forward returns the output of the layer for the batch x, and whatever
+    // Backward will need to know about this pass. r is only used while
+    // training, for random choices such as dropout masks.
+    Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{})
+    // Backward takes partial C/partial output for the pass that returned
+    // cache and returns partial C/partial input, along with the gradients of
+    // the layer's parameters summed over the batch, in the order of Params
+    Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat)
+    // Params returns the trainable parameters of the layer, with Grad set to
+    // the matrices Grads returns
+    Params() []Param
+    // Grads returns the gradients of the parameters for the last mini batch
+    // trained on, in the order of Params. They are 0 before training.
+    Grads() []*mottuMat.MottuMat
+}
+
+// statefulLayer is implemented by layers that learn from the training data
+// outside of gradient descent, such as BatchNorm's running statistics
+type statefulLayer interface {
+    // update is given the caches of the training passes of a mini batch once
+    // it's done, always in the same order, so training stays deterministic
+    update(caches []interface{})
+    // state returns the matrices besides Params that make up the layer
+    state() []*mottuMat.MottuMat
+}
+
+// Sequential feeds its input through a list of layers, one after the other
+type Sequential struct {
+    layers []Layer
+}
+
+// MakeSequential chains the layers. The output of every layer has to be the
+// size of the input of the next.
+func MakeSequential(layers ...Layer) *Sequential {
+    if len(layers) == 0 {
+        panic("A model needs at least one layer")
+    }
+    for i := 1; i < len(layers); i++ {
+        if layers[i].InputSize() != layers[i-1].OutputSize() {
+            panic(fmt.Sprintf("Layer %d takes %d inputs but layer %d outputs %d",
+                              i, layers[i].InputSize(), i-1, layers[i-1].OutputSize()))
+        }
+    }
+    return &Sequential{append([]Layer(nil), layers...)}
+}
+
+// Layers returns the layers of the model in order
+func (s *Sequential) Layers() []Layer {
+    return append([]Layer(nil), s.layers...)
+}
+
+func (s *Sequential) InputSize() int {
+    return s.layers[0].InputSize()
+}
+
+func (s *Sequential) OutputSize() int {
+    return s.layers[len(s.layers)-1].OutputSize()
+}
+
+// Forward runs the batch x through every layer, returning the output of the
+// last one and the cache of each
+func (s *Sequential) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, []interface{}) {
+    return forward_layers(s.layers, x, train, r)
+}
+
+// Backward takes partial C/partial output for the pass that returned caches
+// and returns partial C/partial input, along with the gradients of all the
+// parameters in the order of Params
+func (s *Sequential) Backward(caches []interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    return backward_layers(s.layers, caches, delta)
+}
+
+// Params returns the parameters of every layer in order
+func (s *Sequential) Params() []Param {
+    var params []Param
+    for _, l := range s.layers {
+        params = append(params, l.Params()...)
+    }
+    return params
+}
+
+// Grads returns the gradients of every layer in order
+func (s *Sequential) Grads() []*mottuMat.MottuMat {
+    var grads []*mottuMat.MottuMat
+    for _, l := range s.layers {
+        grads = append(grads, l.Grads()...)
+    }
+    return grads
+}
+
+func forward_layers(layers []Layer, x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, []interface{}) {
+    caches := make([]interface{}, len(layers))
+    for i, l := range layers {
+        x, caches[i] = l.Forward(x, train, r)
+    }
+    return x, caches
+}
+
+func backward_layers(layers []Layer, caches []interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    layer_nabla := make([][]*mottuMat.MottuMat, len(layers))
+    for i := len(layers)-1; i >= 0; i-- {
+        delta, layer_nabla[i] = layers[i].Backward(caches[i], delta)
+    }
+    var nabla []*mottuMat.MottuMat
+    for _, g := range layer_nabla {
+        nabla = append(nabla, g...)
+    }
+    return delta, nabla
+}
+
+// ==================== Dense ===============
+
+// Dense is a fully connected layer computing z = w.x + b. The activation is
+// a layer of its own.
+type Dense struct {
+    weights *mottuMat.MottuMat // weights[j][k] connects input k to neuron j
+    biases *mottuMat.MottuMat
+    grad_w, grad_b *mottuMat.MottuMat
+}
+
+// MakeDense returns a fully connected layer from in inputs to out neurons.
+// The weights and biases start at 0, see Initialize.
+func MakeDense(in, out int) *Dense {
+    if in <= 0 || out <= 0 {
+        panic(fmt.Sprintf("Invalid dense layer size %dx%d", out, in))
+    }
+    return &Dense{mottuMat.MakeMat(out, in), mottuMat.MakeColVec(out), mottuMat.MakeMat(out, in), mottuMat.MakeColVec(out)}
+}
+
+// Initialize redraws the weights and biases
+func (d *Dense) Initialize(init Initializer, r *rand.Rand) {
+    init.InitBiases(d.biases, r)
+    init.InitWeights(d.weights, d.InputSize(), d.OutputSize(), r)
+}
+
+func (d *Dense) InputSize() int {
+    return d.weights.Cols()
+}
+
+func (d *Dense) OutputSize() int {
+    return d.weights.Rows()
+}
+
+// Weights returns the weight matrix, one row per neuron
+func (d *Dense) Weights() *mottuMat.MottuMat {
+    return d.weights
+}
+
+// Biases returns the bias col vec
+func (d *Dense) Biases() *mottuMat.MottuMat {
+    return d.biases
+}
+
+func (d *Dense) Params() []Param {
+    return []Param{{Value: d.weights, Grad: d.grad_w, IsWeight: true}, {Value: d.biases, Grad: d.grad_b}}
+}
+
+func (d *Dense) Grads() []*mottuMat.MottuMat {
+    return []*mottuMat.MottuMat{d.grad_w, d.grad_b}
+}
+
+// The cache is the input x
+func (d *Dense) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    if x.Cols() == 1 {
+        return mottuMat.EvalLinMatExp(d.weights, x, d.biases), x
+    }
+    z := d.weights.Mul(x)
+    z.AddColEq(d.biases)
+    return z, x
+}
+
+func (d *Dense) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    x := cache.(*mottuMat.MottuMat)
+    nabla_w := delta.MulT(x)
+    nabla_b := delta.SumCols()
+    return d.weights.TMul(delta), []*mottuMat.MottuMat{nabla_w, nabla_b}
+}
+
+// ==================== ActivationLayer ===============
+
+// ActivationLayer applies an Activation to its input
+type ActivationLayer struct {
+    size int
+    act Activation
+}
+
+type activation_cache struct {
+    z *mottuMat.MottuMat
+    a *mottuMat.MottuMat
+}
+
+// MakeActivationLayer returns a layer applying act to inputs of the given size
+func MakeActivationLayer(size int, act Activation) *ActivationLayer {
+    return &ActivationLayer{size, act}
+}
+
+// Activation returns the activation applied by the layer
+func (l *ActivationLayer) Activation() Activation {
+    return l.act
+}
+
+func (l *ActivationLayer) InputSize() int {
+    return l.size
+}
+
+func (l *ActivationLayer) OutputSize() int {
+    return l.size
+}
+
+func (l *ActivationLayer) Params() []Param {
+    return nil
+}
+
+func (l *ActivationLayer) Grads() []*mottuMat.MottuMat {
+    return nil
+}
+
+func (l *ActivationLayer) Forward(x *mottuMat.MottuMat, train bool, r *rand.Rand) (*mottuMat.MottuMat, interface{}) {
+    a := l.act.Value(x)
+    return a, &activation_cache{x, a}
+}
+
+func (l *ActivationLayer) Backward(cache interface{}, delta *mottuMat.MottuMat) (*mottuMat.MottuMat, []*mottuMat.MottuMat) {
+    ac := cache.(*activation_cache)
+    return l.act.Derivative(ac.z, ac.a, delta), nil
+}
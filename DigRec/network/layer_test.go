@@ -0,0 +1,56 @@
+package network
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// With plain SGD every parameter moves by -eta times what Grads returns
+func TestGrads(t *testing.T) {
+    mn := MakeMottuNetFromLayers(MakeDense(3, 4), MakeBatchNorm(4, 0.9), MakeActivationLayer(4, Tanh),
+                                 MakeDense(4, 2), MakeActivationLayer(2, Sigmoid))
+    mn.Initialize(Xavier, rand.New(rand.NewSource(1)))
+    set := &mnist.Set{NRow: 1, NCol: 3}
+    for i := 0; i < 4; i++ {
+        x := mottuMat.MakeColVec(3)
+        y := mottuMat.MakeColVec(2)
+        for j := 0; j < 3; j++ {
+            x.SetElem(j, 0, float64((i+1)*(j-1))/4)
+        }
+        y.SetElem(i%2, 0, 1)
+        set.Images = append(set.Images, x)
+        set.ExpOut = append(set.ExpOut, y)
+    }
+
+    var before []*mottuMat.MottuMat
+    for _, p := range mn.params() {
+        before = append(before, p.Value.Copy())
+    }
+    const eta = 0.5
+    mn.SGD(set, 1, 4, eta)
+    grads := mn.Model().Grads()
+    if len(grads) != len(before) {
+        t.Fatalf("%d gradients for %d parameters", len(grads), len(before))
+    }
+    nonzero := false
+    for i, p := range mn.params() {
+        if p.Grad != grads[i] {
+            t.Fatalf("Params()[%d].Grad isn't Grads()[%d]", i, i)
+        }
+        for r := 0; r < p.Value.Rows(); r++ {
+            for c := 0; c < p.Value.Cols(); c++ {
+                step := before[i].GetElem(r, c) - p.Value.GetElem(r, c)
+                if math.Abs(step - eta*grads[i].GetElem(r, c)) > 1e-12 {
+                    t.Fatalf("param %d (%d, %d) moved by %g, gradient %g", i, r, c, step, grads[i].GetElem(r, c))
+                }
+                nonzero = nonzero || grads[i].GetElem(r, c) != 0
+            }
+        }
+    }
+    if !nonzero {
+        t.Fatal("all gradients are 0")
+    }
+}
@@ -1,16 +1,29 @@
 package network
 import (
     "math"
+    "math/rand"
+    "runtime"
+    "sync"
+    "time"
     "NeuralNetworks/DigRec/mnist"
     "NeuralNetworks/DigRec/mottuMat"
     "fmt"
 )
 
 type mottuNet struct {
-    num_layers int
-    sizes []int
-    biases []*mottuMat.MottuMat
-    weights []*mottuMat.MottuMat
+    model *Sequential
+    cost Cost
+    workers int // goroutines used to backprop a mini batch
+    vectorized bool // backprop a worker's samples as one matrix
+    hooks Hooks
+    validation_data *mnist.Set // evaluated after every epoch when set
+    l1 float64 // regularization parameters, see SetRegularization
+    l2 float64
+    train_size int // samples SGD last trained on, which regularization is scaled by
+    optimizer Optimizer
+    schedule Schedule
+    early_stopping *EarlyStopping
+    rng *rand.Rand // for the random choices made while training
 }
 
 
@@ -28,36 +41,86 @@ ever used in computing the outputs from later layers."""
 */
 func MakeMottuNet(sizes []int) (*mottuNet) {
 
-    retval := new(mottuNet)
-    retval.num_layers = len(sizes)
-    retval.sizes = sizes
-
-    // In the example, the author creates this array as a using 
-    // numpy.random.randn
     // Mottu: We need one vector of biases for each layer
     //        And we need one matrix of weights for each layer
-    //        retval.weights[1= matrix W such that W[j][k] = weight for connection betwen 
+    //        weights[1] = matrix W such that W[j][k] = weight for connection betwen 
     //        kth neuron in 2nd layer and jth neuron in the 3rd layer.
-    //fmt.Println("------------------Biases--------------------")
-    retval.biases = make([]*mottuMat.MottuMat, len(sizes)-1)
-    for i := 0; i < len(retval.biases); i++ {
-        retval.biases[i] = mottuMat.MakeMat(sizes[i+1], 1)
-        retval.biases[i].Randomize()
-        //fmt.Print("b", i, " = ")
-        //retval.biases[i].Print()
-    }
-
-    //fmt.Println("------------------Weights--------------------") 
-    retval.weights = make([]*mottuMat.MottuMat, len(sizes)-1)
-    for i := 0; i < len(retval.weights); i++ {
-        retval.weights[i] = mottuMat.MakeMat(sizes[i+1], sizes[i])
-        retval.weights[i].Randomize()
-        //fmt.Print("w", i, " = ")
-        //retval.weights[i].Print()
+    //        Each of them is a Dense layer followed by its activation.
+    if len(sizes) < 2 {
+        panic("Need an input and an output layer")
     }
+    var layers []Layer
+    for i := 0; i < len(sizes)-1; i++ {
+        layers = append(layers, MakeDense(sizes[i], sizes[i+1]), MakeActivationLayer(sizes[i+1], Sigmoid))
+    }
+    retval := MakeMottuNetFromLayers(layers...)
+
+    // A fixed seed keeps runs reproducible. Call Initialize to pick another
+    // seed or initialization.
+    retval.Initialize(StandardNormal, rand.New(rand.NewSource(DefaultSeed)))
     return retval;
 }
 
+// MakeMottuNetFromLayers returns a network running the layers in order, with
+// the quadratic cost and plain sequential SGD. The layers keep the values
+// they were made with.
+func MakeMottuNetFromLayers(layers ...Layer) (*mottuNet) {
+    retval := new(mottuNet)
+    retval.model = MakeSequential(layers...)
+    retval.set_defaults()
+    return retval
+}
+
+// set_defaults gives a freshly made network the quadratic cost and plain
+// sequential SGD, backpropagating mini batches as matrices
+func (this *mottuNet) set_defaults() {
+    this.cost = Quadratic
+    this.workers = 1
+    this.vectorized = true
+    this.optimizer = MakeSGD()
+    this.rng = rand.New(rand.NewSource(DefaultSeed))
+}
+
+// MakeMottuNetWithActivations is MakeMottuNet with a choice of activation
+// for every non input layer. activations[i] is applied to the output of
+// layer i+1, so len(activations) must be len(sizes)-1.
+func MakeMottuNetWithActivations(sizes []int, activations []Activation) (*mottuNet) {
+    if len(activations) != len(sizes)-1 {
+        panic("Need one activation per non input layer")
+    }
+    retval := MakeMottuNet(sizes)
+    i := 0
+    for _, l := range retval.model.layers {
+        if al, ok := l.(*ActivationLayer); ok {
+            al.act = activations[i]
+            i++
+        }
+    }
+    return retval
+}
+
+// Model returns the layers of the network
+func (this *mottuNet) Model() *Sequential {
+    return this.model
+}
+
+// Activations returns the activation of every ActivationLayer in order. For
+// a network made by MakeMottuNet that is one per non input layer.
+func (this *mottuNet) Activations() []Activation {
+    var acts []Activation
+    for _, l := range this.model.layers {
+        if al, ok := l.(*ActivationLayer); ok {
+            acts = append(acts, al.act)
+        }
+    }
+    return acts
+}
+
+// InputSize returns the number of rows a sample given to FeedForward has
+func (this *mottuNet) InputSize() int {
+    return this.model.InputSize()
+}
+
 // Calclulate the sigmoid function
 func sigmoid(z float64) float64 {
     return 1/(1+math.Exp(-z))
@@ -68,95 +131,261 @@ func sigmoid_prime(z float64) float64 {
     return sigmoid(z)*(1-sigmoid(z))
 }
 
-// Return the vector of partial C_x/partial a for the output activations
-func cost_derivative(output_activations, y *mottuMat.MottuMat) *mottuMat.MottuMat{
-    return output_activations.Sub(y)
+// ==================== mottuNet functions ===============
+
+// SetCost sets the cost function minimized by SGD. The default is Quadratic.
+func (this *mottuNet) SetCost(c Cost) {
+    this.cost = c
 }
 
-// ==================== mottuNet functions ===============
-func (this *mottuNet) FeedForward(a *mottuMat.MottuMat) *mottuMat.MottuMat {
-    num_non_input_layers := this.num_layers-1  
+// Cost returns the cost function minimized by SGD
+func (this *mottuNet) Cost() Cost {
+    return this.cost
+}
 
-    result := a
-    for i := 0; i < num_non_input_layers; i++ {
-        result = mottuMat.EvalLinMatExp(this.weights[i], result, this.biases[i])
-        result.ApplyFuncEq(sigmoid)
+// TotalCost returns the average cost of the network over a data set,
+// including the regularization term. That is scaled by the size of the
+// training set SGD last ran on, as for the training and validation losses,
+// or by the size of data for a network that wasn't trained.
+func (this *mottuNet) TotalCost(data *mnist.Set) float64 {
+    if data.Count() == 0 {
+        return 0
     }
-    return result
+    total := 0.0
+    sw := data.Sweep()
+    image, exp_out, present := sw.Next()
+    for present {
+        total += this.cost.Fn(this.FeedForward(image), exp_out)
+        image, exp_out, present = sw.Next()
+    }
+    n := this.train_size
+    if n == 0 {
+        n = data.Count()
+    }
+    return total/float64(data.Count()) + this.regularization_cost(n)
 }
 
+// SetWorkers sets how many goroutines compute the gradients of a mini batch.
+// n <= 0 uses one per CPU. The result of training only depends on the seed
+// and the number of workers, not on how the goroutines get scheduled. Only
+// dropout masks depend on the number of workers, so without dropout it
+// changes nothing but the rounding. Networks with a BatchNorm layer take its
+// statistics over the whole mini batch, so they backprop it in one piece
+// whatever n is.
+func (this *mottuNet) SetWorkers(n int) {
+    if n <= 0 {
+        n = runtime.NumCPU()
+    }
+    this.workers = n
+}
 
-func (this *mottuNet) update_mini_batch(sw *mnist.Sweeper, mini_batch_size int, eta float64) {
-    num_non_input_layers := this.num_layers-1
-    nabla_b := make([]*mottuMat.MottuMat, num_non_input_layers)
-    nabla_w := make([]*mottuMat.MottuMat, num_non_input_layers)
+// SetVectorized switches between backpropagating samples one column vector
+// at a time and stacking all the samples of a worker into one matrix, so each
+// layer is a single matrix-matrix product. The vectorized path is much faster
+// and the default; the per sample one gives the same gradients up to
+// rounding and is kept as a fallback.
+func (this *mottuNet) SetVectorized(on bool) {
+    this.vectorized = on
+}
 
-    
-    x, y, present := sw.Next()
-    if present {
-        delta_nabla_b, delta_nabla_w := this.backprop(x, y)
-        for i := 0; i < num_non_input_layers; i++ {
-            nabla_b[i] = delta_nabla_b[i]
-            nabla_w[i] = delta_nabla_w[i]
+// FeedForward returns the output of the network for a. It only reads the
+// network, so like Predict and PredictMat it can be called from several
+// goroutines at once, as long as nothing trains or changes the network
+// meanwhile.
+func (this *mottuNet) FeedForward(a *mottuMat.MottuMat) *mottuMat.MottuMat {
+    result, _ := this.model.Forward(a, false, nil)
+    return result
+}
+
+// TryFeedForward is FeedForward returning a *mottuMat.ShapeError instead of
+// panicking when a isn't a col vec the size of the network's input
+func (this *mottuNet) TryFeedForward(a *mottuMat.MottuMat) (*mottuMat.MottuMat, error) {
+    if a.Rows() != this.InputSize() || a.Cols() != 1 {
+        return nil, &mottuMat.ShapeError{
+            Op: "FeedForward",
+            A: mottuMat.Shape{Rows: this.InputSize(), Cols: 1},
+            B: a.Shape(),
         }
+    }
+    return this.FeedForward(a), nil
+}
+
 
+// params lists every trainable matrix of the network, layer by layer.
+// Gradients are passed around as slices in the same order.
+func (this *mottuNet) params() []Param {
+    return this.model.Params()
+}
+
+// update_mini_batch takes an optimizer step on the samples left in sw.
+// n is the size of the whole training set, used to scale regularization.
+// Returns the total cost of those samples before the step and their count.
+func (this *mottuNet) update_mini_batch(sw *mnist.Sweeper, mini_batch_size int, eta float64, n int) (float64, int) {
+    var xs, ys []*mottuMat.MottuMat
+    x, y, present := sw.Next()
+    for present {
+        xs = append(xs, x)
+        ys = append(ys, y)
         x, y, present = sw.Next()
+    }
+    if len(xs) == 0 {
+        return 0, 0
+    }
 
-        for present {
-            delta_nabla_b, delta_nabla_w = this.backprop(x, y)
-            for i := 0; i < num_non_input_layers; i++ {
-                nabla_b[i].AddEq(delta_nabla_b[i])
-                nabla_w[i].AddEq(delta_nabla_w[i])
-            }
+    nabla, cost, stats := this.batch_gradients(xs, ys)
+    factor := 1/float64(mini_batch_size)
+    params := this.params()
+    for i := range params {
+        nabla[i].ScaleEq(factor)
+        params[i].Grad.CopyFrom(nabla[i])
+    }
+    this.regularize(params, n)
+    this.optimizer.Step(params, eta)
+    this.update_stats(stats)
+    return cost, len(xs)
+}
 
-            x, y, present = sw.Next()
+// batch_gradients returns the gradients and the cost summed over all the
+// samples, along with the stats of every training pass (see backprop). The
+// samples are split into one contiguous chunk per worker, and the per worker
+// results are put together in worker order so they are deterministic.
+func (this *mottuNet) batch_gradients(xs, ys []*mottuMat.MottuMat) ([]*mottuMat.MottuMat, float64, [][]interface{}) {
+    workers := this.workers
+    if workers > len(xs) {
+        workers = len(xs)
+    }
+    // Batch statistics are over the whole mini batch, not a worker's chunk
+    if workers <= 1 || this.has_state() {
+        return this.sum_backprop(xs, ys, this.rng)
+    }
+
+    // Every worker gets its own source, seeded in worker order
+    rngs := make([]*rand.Rand, workers)
+    for w := 0; w < workers; w++ {
+        rngs[w] = rand.New(rand.NewSource(this.rng.Int63()))
+    }
+
+    partial_nabla := make([][]*mottuMat.MottuMat, workers)
+    partial_cost := make([]float64, workers)
+    partial_stats := make([][][]interface{}, workers)
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        lo := w*len(xs)/workers
+        hi := (w+1)*len(xs)/workers
+        wg.Add(1)
+        go func(w, lo, hi int) {
+            defer wg.Done()
+            partial_nabla[w], partial_cost[w], partial_stats[w] = this.sum_backprop(xs[lo:hi], ys[lo:hi], rngs[w])
+        }(w, lo, hi)
+    }
+    wg.Wait()
+
+    nabla, cost, stats := partial_nabla[0], partial_cost[0], partial_stats[0]
+    for w := 1; w < workers; w++ {
+        for i := 0; i < len(nabla); i++ {
+            nabla[i].AddEq(partial_nabla[w][i])
         }
+        cost += partial_cost[w]
+        stats = append(stats, partial_stats[w]...)
     }
-    factor := eta/float64(mini_batch_size)
-    for i := 0; i < num_non_input_layers; i++ {
-        nabla_w[i].ScaleEq(factor)
-        this.weights[i].SubEq(nabla_w[i])
+    return nabla, cost, stats
+}
 
-        nabla_b[i].ScaleEq(factor)
-        this.biases[i].SubEq(nabla_b[i])
+// sum_backprop runs backprop on every sample and adds up the gradients and
+// the cost. It only reads the network, so several can run at once as long as
+// each has its own r.
+func (this *mottuNet) sum_backprop(xs, ys []*mottuMat.MottuMat, r *rand.Rand) ([]*mottuMat.MottuMat, float64, [][]interface{}) {
+    // Batch statistics need more than one sample at a time
+    if this.vectorized || this.has_state() {
+        nabla, cost, stats := this.backprop(mottuMat.HStack(xs), mottuMat.HStack(ys), r)
+        return nabla, cost, [][]interface{}{stats}
+    }
+    nabla, cost, _ := this.backprop(xs[0], ys[0], r)
+    for k := 1; k < len(xs); k++ {
+        delta_nabla, delta_cost, _ := this.backprop(xs[k], ys[k], r)
+        for i := 0; i < len(nabla); i++ {
+            nabla[i].AddEq(delta_nabla[i])
+        }
+        cost += delta_cost
     }
+    return nabla, cost, nil
 }
 
-func (this *mottuNet) backprop(x , y *mottuMat.MottuMat) ([]*mottuMat.MottuMat, []*mottuMat.MottuMat) {
-    num_non_input_layers := this.num_layers-1
-    nabla_b := make([]*mottuMat.MottuMat, num_non_input_layers)
-    nabla_w := make([]*mottuMat.MottuMat, num_non_input_layers)
+// backprop returns the gradients of the cost for the batch x, in the order
+// of params(), along with the cost summed over the batch. Column k of x and
+// y is the kth sample. r draws the dropout masks. The last result holds the
+// cache of every statefulLayer for update_stats, and is nil when there are
+// none.
+func (this *mottuNet) backprop(x, y *mottuMat.MottuMat, r *rand.Rand) ([]*mottuMat.MottuMat, float64, []interface{}) {
+    // When the last layer is an activation, the cost hands back partial
+    // C/partial z directly, which is where e.g. softmax and log-likelihood
+    // simplify to a-y
+    layers := this.model.layers
+    out_act := Identity
+    if al, ok := layers[len(layers)-1].(*ActivationLayer); ok {
+        out_act = al.act
+        layers = layers[:len(layers)-1]
+    }
 
     // feedforward
-    activation := x
-    activations := make([]*mottuMat.MottuMat, this.num_layers) // num_non_input_layers+1
-    activations[0] = activation
-    zs := make([]*mottuMat.MottuMat, num_non_input_layers)
-    for i := 0; i < num_non_input_layers; i++ {
-        zs[i] = mottuMat.EvalLinMatExp(this.weights[i], activation, this.biases[i])
-        activation = zs[i].ApplyFunc(sigmoid)
-        activations[i+1] = activation
-    }
+    z, caches := forward_layers(layers, x, true, r)
+    a := out_act.Value(z)
+
     // backward pass
-    
+    cost := this.cost.Fn(a, y)
+    _, nabla := backward_layers(layers, caches, this.cost.Delta(z, a, y, out_act))
 
-    delta := cost_derivative(activations[len(activations)-1], y)
-    sp := zs[len(zs)-1].ApplyFunc(sigmoid_prime)
-    delta.HadMulEq(sp)
-    nabla_b[len(nabla_b)-1] = delta
-    nabla_w[len(nabla_w)-1] = delta.BroadMul(activations[len(activations)-2])
+    var stats []interface{}
+    if this.has_state() {
+        stats = make([]interface{}, len(this.model.layers))
+        for i, l := range layers {
+            if _, ok := l.(statefulLayer); ok {
+                stats[i] = caches[i]
+            }
+        }
+    }
+    return nabla, cost, stats
+}
 
-    for l := 2; l < this.num_layers; l++ {
+// has_state says whether any layer is a statefulLayer
+func (this *mottuNet) has_state() bool {
+    for _, l := range this.model.layers {
+        if _, ok := l.(statefulLayer); ok {
+            return true
+        }
+    }
+    return false
+}
 
-        sp = zs[len(zs)-l].ApplyFunc(sigmoid_prime)
-        weights_t := this.weights[len(this.weights)-l+1].Transpose()
-        delta = weights_t.Mul(delta)
-        delta.HadMulEq(sp)
+// update_stats hands every statefulLayer its caches from the training passes
+// of a mini batch, in order
+func (this *mottuNet) update_stats(stats [][]interface{}) {
+    for i, l := range this.model.layers {
+        sl, ok := l.(statefulLayer)
+        if !ok {
+            continue
+        }
+        caches := make([]interface{}, 0, len(stats))
+        for _, pass := range stats {
+            caches = append(caches, pass[i])
+        }
+        sl.update(caches)
+    }
+}
 
-        nabla_b[len(nabla_b)-l] = delta
-        nabla_w[len(nabla_w)-l] = delta.BroadMul(activations[len(activations)-l-1])
+// state_mats lists the values of params() followed by the state of every
+// statefulLayer, which together are all the network has learned
+func (this *mottuNet) state_mats() []*mottuMat.MottuMat {
+    var mats []*mottuMat.MottuMat
+    for _, p := range this.params() {
+        mats = append(mats, p.Value)
+    }
+    for _, l := range this.model.layers {
+        if sl, ok := l.(statefulLayer); ok {
+            mats = append(mats, sl.state()...)
+        }
     }
-    return nabla_b, nabla_w
+    return mats
 }
 
 /*
@@ -165,48 +394,106 @@ func (this *mottuNet) backprop(x , y *mottuMat.MottuMat) ([]*mottuMat.MottuMat,
 */
 func (this *mottuNet) SGD(training_data *mnist.Set, epochs int, mini_batch_size int, eta float64) {
     n := training_data.Count()
-    sw := training_data.Sweep()
+    this.train_size = n
+    sw := training_data.SweepWith(rand.New(rand.NewSource(this.rng.Int63())))
+    start := time.Now()
+    var tracker *early_stop_tracker
+    if this.early_stopping != nil && this.validation_data != nil && this.validation_data.Count() > 0 {
+        tracker = &early_stop_tracker{config: *this.early_stopping}
+        defer tracker.restore(this)
+    }
  
     for j := 0; j < epochs; j++ {
-        fmt.Println("Epoch ", j)
+        if this.hooks == nil {
+            fmt.Println("Epoch ", j)
+        } else {
+            this.hooks.OnEpochStart(j)
+        }
+        epoch_start := time.Now()
+        total_cost := 0.0
+        seen := 0
         sw.Shuffle()
+        rate := eta
         for k := 0; k <= n-mini_batch_size; k+= mini_batch_size {
+            if this.schedule != nil {
+                rate = this.schedule.Rate(eta, Progress{j, k/mini_batch_size, n/mini_batch_size})
+            }
             sw.SetBounds(k, k+mini_batch_size)
-            this.update_mini_batch(sw, mini_batch_size, eta)
+            cost, count := this.update_mini_batch(sw, mini_batch_size, rate, n)
+            total_cost += cost
+            seen += count
+            if this.hooks != nil {
+                this.hooks.OnBatchEnd(j, k/mini_batch_size, total_cost/float64(seen) + this.regularization_cost(n))
+            }
+        }
+
+        stats := EpochStats{
+            Epoch: j,
+            Eta: rate,
+            Elapsed: time.Since(start),
+            EpochTime: time.Since(epoch_start),
+        }
+        if seen > 0 {
+            stats.Loss = total_cost/float64(seen) + this.regularization_cost(n)
+        }
+        if this.validation_data != nil && this.validation_data.Count() > 0 {
+            // The loss is only worth a pass over the cost when something
+            // looks at it
+            with_loss := this.hooks != nil || (tracker != nil && tracker.config.Monitor == MonitorLoss)
+            stats.Validated = true
+            stats.NumCorrect, stats.ValidationLoss = this.validate(this.validation_data, with_loss, n)
+            stats.Accuracy = float64(stats.NumCorrect)/float64(this.validation_data.Count())
+            if obs, ok := this.schedule.(MetricObserver); ok {
+                obs.Observe(stats.Accuracy)
+            }
+        }
+        if tracker != nil {
+            stats.EarlyStopped = tracker.update(this, stats)
+        }
+        if this.hooks != nil && this.hooks.OnEpochEnd(stats) {
+            return
+        }
+        if stats.EarlyStopped {
+            return
+        }
+    }
+}
+
+// validate feeds data forward once, returning the number of samples classified
+// correctly and, if with_loss is set, their average cost. Regularization is
+// scaled by the size n of the training set, like the training loss, so the
+// two can be compared.
+func (this *mottuNet) validate(data *mnist.Set, with_loss bool, n int) (int, float64) {
+    num_correct := 0
+    total := 0.0
+    sw := data.Sweep()
+    image, exp_out, present := sw.Next()
+    for present {
+        out := this.FeedForward(image)
+        if argmax(out) == argmax(exp_out) {
+            num_correct++
+        }
+        if with_loss {
+            total += this.cost.Fn(out, exp_out)
         }
+        image, exp_out, present = sw.Next()
+    }
+    if !with_loss {
+        return num_correct, 0
     }
+    return num_correct, total/float64(data.Count()) + this.regularization_cost(n)
 }
 
 // Returns the number of test inputs for which mottuNet outputs the 
-// correct result
+// correct result, the same count EvaluateDetailed gives
 func (this *mottuNet) Evaluate(test_data *mnist.Set) int {
     num_correct := 0
     sw := test_data.Sweep()
     image, exp_out, present := sw.Next()
     for present {
-        test_result := this.FeedForward(image)
-        count_to_add := 1
-        max_act_index_mottu := -1
-        max_val_mottu := 0.0
-        max_act_index_exp := -1
-        max_val_exp := 0.0
-        result_len := test_result.Rows()
-        
-        for i := 0; i < result_len; i++ {
-            if test_result.GetElem(i, 0) > max_val_mottu {
-                max_act_index_mottu = i
-                max_val_mottu = test_result.GetElem(i, 0)
-            }
-
-            if exp_out.GetElem(i, 0) > max_val_exp {
-                max_act_index_exp = i
-                max_val_exp = exp_out.GetElem(i, 0)
-            }
-        }
-        if max_act_index_mottu != max_act_index_exp {
-            count_to_add = 0
+        if argmax(this.FeedForward(image)) == argmax(exp_out) {
+            num_correct++
         }
-        num_correct += count_to_add
         image, exp_out, present = sw.Next()
     }
     return num_correct
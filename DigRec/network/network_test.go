@@ -0,0 +1,163 @@
+package network
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// test_set returns n samples of a made up problem with in inputs and out
+// classes
+func test_set(n, in, out int, seed int64) *mnist.Set {
+    r := rand.New(rand.NewSource(seed))
+    set := &mnist.Set{NRow: 1, NCol: in}
+    for i := 0; i < n; i++ {
+        label := r.Intn(out)
+        x := mottuMat.MakeColVec(in)
+        for j := 0; j < in; j++ {
+            x.SetElem(j, 0, 0.3*r.Float64())
+        }
+        x.SetElem(label%in, 0, 1)
+        y := mottuMat.MakeColVec(out)
+        y.SetElem(label, 0, 1)
+        set.Images = append(set.Images, x)
+        set.ExpOut = append(set.ExpOut, y)
+    }
+    return set
+}
+
+func TestVectorizedDefault(t *testing.T) {
+    if !MakeMottuNet([]int{2, 2}).vectorized {
+        t.Fatal("networks should backprop mini batches as matrices by default")
+    }
+}
+
+// Backpropagating a mini batch as one matrix gives the same training as
+// going one sample at a time
+func TestVectorizedMatchesPerSample(t *testing.T) {
+    set := test_set(40, 6, 3, 1)
+    var nets [2]*mottuNet
+    for i, vectorized := range []bool{true, false} {
+        nets[i] = MakeMottuNetWithActivations([]int{6, 5, 3}, []Activation{Tanh, Sigmoid})
+        nets[i].SetCost(CrossEntropy)
+        nets[i].SetWorkers(2)
+        nets[i].SetVectorized(vectorized)
+        nets[i].SGD(set, 2, 8, 0.5)
+    }
+    a, b := nets[0].params(), nets[1].params()
+    for i := range a {
+        for r := 0; r < a[i].Value.Rows(); r++ {
+            for c := 0; c < a[i].Value.Cols(); c++ {
+                if d := math.Abs(a[i].Value.GetElem(r, c) - b[i].Value.GetElem(r, c)); d > 1e-10 {
+                    t.Fatalf("param %d (%d, %d) differs by %g", i, r, c, d)
+                }
+            }
+        }
+    }
+}
+
+type record_hooks struct {
+    BaseHooks
+    stats []EpochStats
+}
+
+func (h *record_hooks) OnEpochEnd(stats EpochStats) bool {
+    h.stats = append(h.stats, stats)
+    return false
+}
+
+// The validation loss regularizes like the training loss, by the size of
+// the training set
+func TestValidationLoss(t *testing.T) {
+    train, validation := test_set(30, 4, 2, 1), test_set(10, 4, 2, 2)
+    mn := MakeMottuNet([]int{4, 3, 2})
+    mn.SetRegularization(0, 0.5)
+    mn.SetValidationData(validation)
+    hooks := &record_hooks{}
+    mn.SetHooks(hooks)
+    mn.SGD(train, 2, 5, 0.5)
+
+    last := hooks.stats[len(hooks.stats)-1]
+    want := mn.TotalCost(validation)
+    if !last.Validated || math.Abs(last.ValidationLoss - want) > 1e-12 {
+        t.Errorf("validation loss %g, want %g", last.ValidationLoss, want)
+    }
+    if last.NumCorrect != mn.Evaluate(validation) {
+        t.Errorf("validation counts %d correct, Evaluate %d", last.NumCorrect, mn.Evaluate(validation))
+    }
+}
+
+// max_param_diff returns the largest difference between the parameters of
+// two networks of the same shape
+func max_param_diff(a, b *mottuNet) float64 {
+    pa, pb := a.params(), b.params()
+    diff := 0.0
+    for i := range pa {
+        for r := 0; r < pa[i].Value.Rows(); r++ {
+            for c := 0; c < pa[i].Value.Cols(); c++ {
+                diff = math.Max(diff, math.Abs(pa[i].Value.GetElem(r, c) - pb[i].Value.GetElem(r, c)))
+            }
+        }
+    }
+    return diff
+}
+
+// The order SGD visits the samples in comes from SetRand, so the same seed
+// trains the same network and another seed a different one
+func TestShuffleSeed(t *testing.T) {
+    set := test_set(30, 4, 2, 1)
+    var nets [3]*mottuNet
+    for i, seed := range []int64{1, 1, 2} {
+        nets[i] = MakeMottuNet([]int{4, 3, 2})
+        nets[i].SetRand(rand.New(rand.NewSource(seed)))
+        nets[i].SetHooks(&record_hooks{})
+        nets[i].SGD(set, 2, 5, 0.5)
+    }
+    if d := max_param_diff(nets[0], nets[1]); d != 0 {
+        t.Errorf("the same seed gave params %g apart", d)
+    }
+    if max_param_diff(nets[0], nets[2]) == 0 {
+        t.Error("another seed gave the same params")
+    }
+}
+
+// Without dropout the number of workers only changes how the gradients of a
+// mini batch are summed, so one worker and several train the same network
+// up to rounding
+func TestWorkersMatch(t *testing.T) {
+    set := test_set(40, 6, 3, 1)
+    var nets [3]*mottuNet
+    for i, workers := range []int{1, 3, 4} {
+        nets[i] = MakeMottuNetWithActivations([]int{6, 5, 3}, []Activation{Tanh, Sigmoid})
+        nets[i].SetRand(rand.New(rand.NewSource(5)))
+        nets[i].SetWorkers(workers)
+        nets[i].SetHooks(&record_hooks{})
+        nets[i].SGD(set, 3, 10, 0.5)
+    }
+    for i := 1; i < len(nets); i++ {
+        if d := max_param_diff(nets[0], nets[i]); d > 1e-12 {
+            t.Errorf("%d workers gave params %g away from 1 worker", nets[i].workers, d)
+        }
+    }
+}
+
+// With dropout every worker draws from its own source, seeded in order, so
+// the same seed and number of workers train the same network however the
+// goroutines run
+func TestWorkersDeterministic(t *testing.T) {
+    set := test_set(40, 6, 3, 1)
+    var nets [2]*mottuNet
+    for i := range nets {
+        nets[i] = MakeMottuNet([]int{6, 8, 3})
+        nets[i].SetDropout(1, 0.5)
+        nets[i].SetRand(rand.New(rand.NewSource(5)))
+        nets[i].SetWorkers(4)
+        nets[i].SetHooks(&record_hooks{})
+        nets[i].SGD(set, 3, 10, 0.5)
+    }
+    if d := max_param_diff(nets[0], nets[1]); d != 0 {
+        t.Errorf("the same seed and workers gave params %g apart", d)
+    }
+}
@@ -0,0 +1,253 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// Param is a trainable matrix along with the gradient of the cost with
+// respect to it, averaged over the mini batch
+type Param struct {
+    Value *mottuMat.MottuMat
+    Grad *mottuMat.MottuMat
+    IsWeight bool // weights are regularized and decayed, biases aren't
+}
+
+// Optimizer turns gradients into updates. Optimizers with state (velocities,
+// moment estimates) keep one entry per parameter, matched up by position, so
+// Step must be given the parameters in the same order every time.
+type Optimizer interface {
+    Name() string
+    // Step updates every param.Value in place using learning rate eta
+    Step(params []Param, eta float64)
+}
+
+// Added to denominators so they are never 0
+const optimizerEpsilon = 1e-8
+
+// ParseOptimizer returns an optimizer with default settings from its name:
+// sgd, momentum, nesterov, adagrad, rmsprop, adam or adamw. momentum and
+// nesterov take the momentum coefficient as "momentum:0.9", adamw takes the
+// weight decay as "adamw:0.01".
+func ParseOptimizer(name string) (Optimizer, error) {
+    base, param, has_param := strings.Cut(strings.ToLower(strings.TrimSpace(name)), ":")
+    value := 0.0
+    if has_param {
+        var err error
+        if value, err = strconv.ParseFloat(param, 64); err != nil {
+            return nil, fmt.Errorf("network: bad parameter for optimizer %q: %w", name, err)
+        }
+    }
+    switch base {
+    case "sgd":
+        return MakeSGD(), nil
+    case "momentum":
+        if !has_param {
+            value = 0.9
+        }
+        return MakeMomentum(value), nil
+    case "nesterov":
+        if !has_param {
+            value = 0.9
+        }
+        return MakeNesterov(value), nil
+    case "adagrad":
+        return MakeAdaGrad(), nil
+    case "rmsprop":
+        return MakeRMSProp(0.9), nil
+    case "adam":
+        return MakeAdam(0.9, 0.999), nil
+    case "adamw":
+        if !has_param {
+            value = 0.01
+        }
+        return MakeAdamW(0.9, 0.999, value), nil
+    }
+    return nil, fmt.Errorf("network: unknown optimizer %q", name)
+}
+
+// ==================== SGD ===============
+
+type sgd struct{}
+
+// MakeSGD returns plain gradient descent: p -> p - eta g
+func MakeSGD() Optimizer {
+    return sgd{}
+}
+
+func (sgd) Name() string {
+    return "sgd"
+}
+
+func (sgd) Step(params []Param, eta float64) {
+    for _, p := range params {
+        p.Value.SubEq(p.Grad.Scale(eta))
+    }
+}
+
+// ==================== Momentum and Nesterov ===============
+
+type momentum struct {
+    mu float64
+    nesterov bool
+    velocity []*mottuMat.MottuMat
+}
+
+// MakeMomentum returns gradient descent with momentum coefficient mu:
+// v -> mu v - eta g, p -> p + v
+func MakeMomentum(mu float64) Optimizer {
+    return &momentum{mu: mu}
+}
+
+// MakeNesterov returns Nesterov accelerated gradient descent, which applies
+// the momentum step before looking at the gradient
+func MakeNesterov(mu float64) Optimizer {
+    return &momentum{mu: mu, nesterov: true}
+}
+
+func (o *momentum) Name() string {
+    if o.nesterov {
+        return "nesterov"
+    }
+    return "momentum"
+}
+
+func (o *momentum) Step(params []Param, eta float64) {
+    o.velocity = grow_state(o.velocity, params)
+    for i, p := range params {
+        v := o.velocity[i]
+        v_prev := v.Scale(1)
+        v.ScaleEq(o.mu)
+        v.SubEq(p.Grad.Scale(eta))
+        if o.nesterov {
+            // The gradient was taken at p rather than at p + mu v, so
+            // correct for that: p -> p - mu v_prev + (1+mu) v
+            v_prev.ScaleEq(-o.mu)
+            v_prev.AddEq(v.Scale(1+o.mu))
+            p.Value.AddEq(v_prev)
+        } else {
+            p.Value.AddEq(v)
+        }
+    }
+}
+
+// ==================== AdaGrad and RMSProp ===============
+
+type adaptive struct {
+    name string
+    rho float64 // decay of the squared gradient average, 1 for AdaGrad's plain sum
+    decay bool
+    sq_sum []*mottuMat.MottuMat
+}
+
+// MakeAdaGrad returns AdaGrad, which divides the step of every parameter by
+// the square root of the sum of its squared gradients so far
+func MakeAdaGrad() Optimizer {
+    return &adaptive{name: "adagrad", rho: 1}
+}
+
+// MakeRMSProp returns RMSProp, which divides the step of every parameter by
+// the square root of a moving average of its squared gradients
+func MakeRMSProp(rho float64) Optimizer {
+    return &adaptive{name: "rmsprop", rho: rho, decay: true}
+}
+
+func (o *adaptive) Name() string {
+    return o.name
+}
+
+func (o *adaptive) Step(params []Param, eta float64) {
+    o.sq_sum = grow_state(o.sq_sum, params)
+    for k, p := range params {
+        s := o.sq_sum[k]
+        for i := 0; i < s.Rows(); i++ {
+            for j := 0; j < s.Cols(); j++ {
+                g := p.Grad.GetElem(i, j)
+                if o.decay {
+                    s.SetElem(i, j, o.rho*s.GetElem(i, j) + (1-o.rho)*g*g)
+                } else {
+                    s.SetElem(i, j, s.GetElem(i, j) + g*g)
+                }
+                step := eta*g/(math.Sqrt(s.GetElem(i, j)) + optimizerEpsilon)
+                p.Value.SetElem(i, j, p.Value.GetElem(i, j) - step)
+            }
+        }
+    }
+}
+
+// ==================== Adam and AdamW ===============
+
+type adam struct {
+    beta1 float64
+    beta2 float64
+    weight_decay float64
+    t int
+    m []*mottuMat.MottuMat // first moment
+    v []*mottuMat.MottuMat // second moment
+}
+
+// MakeAdam returns Adam with the given decay rates for the first and second
+// moment estimates, usually 0.9 and 0.999
+func MakeAdam(beta1, beta2 float64) Optimizer {
+    return &adam{beta1: beta1, beta2: beta2}
+}
+
+// MakeAdamW returns Adam with decoupled weight decay: weights also shrink
+// by eta*weight_decay*w every step, independently of the adaptive scaling.
+// Biases are not decayed.
+func MakeAdamW(beta1, beta2, weight_decay float64) Optimizer {
+    return &adam{beta1: beta1, beta2: beta2, weight_decay: weight_decay}
+}
+
+func (o *adam) Name() string {
+    if o.weight_decay != 0 {
+        return "adamw"
+    }
+    return "adam"
+}
+
+func (o *adam) Step(params []Param, eta float64) {
+    o.m = grow_state(o.m, params)
+    o.v = grow_state(o.v, params)
+    o.t++
+    // Bias corrections for the moments starting out at 0
+    c1 := 1 - math.Pow(o.beta1, float64(o.t))
+    c2 := 1 - math.Pow(o.beta2, float64(o.t))
+    for k, p := range params {
+        m, v := o.m[k], o.v[k]
+        for i := 0; i < m.Rows(); i++ {
+            for j := 0; j < m.Cols(); j++ {
+                g := p.Grad.GetElem(i, j)
+                m_ij := o.beta1*m.GetElem(i, j) + (1-o.beta1)*g
+                v_ij := o.beta2*v.GetElem(i, j) + (1-o.beta2)*g*g
+                m.SetElem(i, j, m_ij)
+                v.SetElem(i, j, v_ij)
+
+                w := p.Value.GetElem(i, j)
+                step := eta*(m_ij/c1)/(math.Sqrt(v_ij/c2) + optimizerEpsilon)
+                if p.IsWeight {
+                    step += eta*o.weight_decay*w
+                }
+                p.Value.SetElem(i, j, w - step)
+            }
+        }
+    }
+}
+
+// grow_state makes sure there is a zeroed matrix of state for every param
+func grow_state(state []*mottuMat.MottuMat, params []Param) []*mottuMat.MottuMat {
+    for i := len(state); i < len(params); i++ {
+        state = append(state, mottuMat.MakeMat(params[i].Value.Rows(), params[i].Value.Cols()))
+    }
+    return state
+}
+
+// SetOptimizer sets the update rule used by SGD. The default is MakeSGD().
+// Optimizers keep state for the network they train, so don't share one
+// between networks.
+func (this *mottuNet) SetOptimizer(opt Optimizer) {
+    this.optimizer = opt
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+    "math"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// run_steps steps opt with learning rate 0.1 on a 1x1 weight and a 1x1 bias,
+// both starting at 1 and getting the same gradients, and returns the weight
+// and the bias after every step
+func run_steps(opt Optimizer, grads ...float64) ([]float64, []float64) {
+    params := make([]Param, 2)
+    for i := range params {
+        params[i] = Param{Value: mottuMat.MakeMat(1, 1), Grad: mottuMat.MakeMat(1, 1), IsWeight: i == 0}
+        params[i].Value.SetElem(0, 0, 1)
+    }
+    var weights, biases []float64
+    for _, g := range grads {
+        for _, p := range params {
+            p.Grad.SetElem(0, 0, g)
+        }
+        opt.Step(params, 0.1)
+        weights = append(weights, params[0].Value.GetElem(0, 0))
+        biases = append(biases, params[1].Value.GetElem(0, 0))
+    }
+    return weights, biases
+}
+
+func check_steps(t *testing.T, name string, got, want []float64) {
+    t.Helper()
+    for i := range want {
+        if math.Abs(got[i] - want[i]) > 1e-12 {
+            t.Errorf("%s: step %d gives %.15g, want %.15g", name, i+1, got[i], want[i])
+        }
+    }
+}
+
+func TestSGDStep(t *testing.T) {
+    w, b := run_steps(MakeSGD(), 0.5, -0.2)
+    check_steps(t, "weight", w, []float64{0.95, 0.97})
+    check_steps(t, "bias", b, []float64{0.95, 0.97})
+}
+
+// With mu 0.9: v1 = -0.05, v2 = 0.9 v1 + 0.02 = -0.025. Momentum adds v,
+// Nesterov adds -mu v_prev + (1+mu) v: -0.095, then 0.045 - 0.0475.
+func TestMomentumSteps(t *testing.T) {
+    w, _ := run_steps(MakeMomentum(0.9), 0.5, -0.2)
+    check_steps(t, "momentum", w, []float64{0.95, 0.925})
+    w, b := run_steps(MakeNesterov(0.9), 0.5, -0.2)
+    check_steps(t, "nesterov weight", w, []float64{0.905, 0.9025})
+    check_steps(t, "nesterov bias", b, []float64{0.905, 0.9025})
+}
+
+// With betas 0.9 and 0.999:
+//
+//  step 1: m = 0.05, v = 0.00025, corrected by 1-0.9 and 1-0.999 to 0.5 and
+//          0.25, so the first step is eta whatever the size of g
+//  step 2: m = 0.025, v = 0.00028975, corrected by 0.19 and 0.001999
+func TestAdamSteps(t *testing.T) {
+    step1 := 0.1*0.5/(0.5 + optimizerEpsilon)
+    step2 := 0.1*(0.025/0.19)/(math.Sqrt(0.00028975/0.001999) + optimizerEpsilon)
+    want := []float64{1 - step1, 1 - step1 - step2}
+    w, b := run_steps(MakeAdam(0.9, 0.999), 0.5, -0.2)
+    check_steps(t, "weight", w, want)
+    check_steps(t, "bias", b, want)
+    if math.Abs(step1 - 0.1) > 1e-8 {
+        t.Errorf("first step %g, want about eta", step1)
+    }
+
+    // Without the bias correction it would be eta*1e-5/sqrt(1e-11), 0.32 eta
+    w, _ = run_steps(MakeAdam(0.9, 0.999), 1e-4)
+    if math.Abs(w[0] - 0.9) > 1e-4 {
+        t.Errorf("a tiny first gradient steps to %g, want about 0.9", w[0])
+    }
+}
+
+// AdamW takes Adam's step and then shrinks weights by eta*decay*w, leaving
+// biases alone. The decay is decoupled from the gradient: a weight with no
+// gradient shrinks by exactly that, rather than by Adam's eta-sized step as
+// with L2 folded into g.
+func TestAdamWSteps(t *testing.T) {
+    step1 := 0.1*0.5/(0.5 + optimizerEpsilon)
+    step2 := 0.1*(0.025/0.19)/(math.Sqrt(0.00028975/0.001999) + optimizerEpsilon)
+    w1 := 1 - step1 - 0.1*0.01*1
+    w, b := run_steps(MakeAdamW(0.9, 0.999, 0.01), 0.5, -0.2)
+    check_steps(t, "weight", w, []float64{w1, w1 - step2 - 0.1*0.01*w1})
+    check_steps(t, "bias", b, []float64{1 - step1, 1 - step1 - step2})
+
+    w, b = run_steps(MakeAdamW(0.9, 0.999, 0.01), 0, 0)
+    check_steps(t, "weight without gradient", w, []float64{0.999, 0.999*0.999})
+    check_steps(t, "bias without gradient", b, []float64{1, 1})
+}
@@ -0,0 +1,829 @@
+package network
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+/*
+A saved mottuNet in the binary format looks like this (everything big endian,
+same as the MNIST files):
+
+    magic       uint32  0x4d4e4554 ("MNET")
+    version     uint32
+    cost        uint16 length followed by the Cost.Name() bytes
+    num_layers  uint32
+    for every layer:
+        kind        uint16 length followed by the name of the layer type
+        shape       uint16 count followed by count x uint32
+        activation  uint16 length followed by the Activation.Name() bytes,
+                    empty for layers without one
+        values      uint16 count followed by count x float64
+        matrices    uint16 count followed by, for every matrix,
+                    rows uint32, cols uint32, rows*cols x float64
+    checksum    uint32  CRC-32 (IEEE) of everything after the version
+
+What shape, values and matrices hold depends on the kind of layer, see
+layerSpec. Versions 1 to 3 only had dense layers, and stored after the
+version:
+
+    num_layers  uint32
+    sizes       num_layers x uint32
+    activations for every non input layer (version 2 and up):
+                uint16 length followed by the Activation.Name() bytes
+    cost        (version 3 and up) uint16 length followed by the Cost.Name() bytes
+    for every non input layer:
+        biases  rows uint32, cols uint32, rows*cols x float64
+        weights rows uint32, cols uint32, rows*cols x float64
+
+The JSON format stores the same fields plus the same checksum, so a model
+can be converted between the two without losing anything. Version 1 models
+predate pluggable activations and are loaded with sigmoid everywhere;
+versions before 3 are loaded with the quadratic cost.
+*/
+const (
+    modelMagic   = 0x4d4e4554
+    modelVersion = 4
+    // Largest value in the shape of a saved layer, so the sizes computed from
+    // a shape can't overflow
+    maxShape = 1 << 20
+)
+
+var (
+    ErrBadMagic   = errors.New("network: not a saved mottuNet model")
+    ErrBadVersion = errors.New("network: unsupported model version")
+    ErrChecksum   = errors.New("network: model checksum mismatch")
+    ErrCorrupt    = errors.New("network: corrupt model")
+    ErrUnsupported = errors.New("network: layer type can't be saved")
+)
+
+type matJSON struct {
+    Rows int `json:"rows"`
+    Cols int `json:"cols"`
+    Data []float64 `json:"data"`
+}
+
+type layerJSON struct {
+    Kind string `json:"kind"`
+    Shape []int `json:"shape,omitempty"`
+    Activation string `json:"activation,omitempty"`
+    Values []float64 `json:"values,omitempty"`
+    Mats []matJSON `json:"mats,omitempty"`
+}
+
+type modelJSON struct {
+    Version int `json:"version"`
+    Cost string `json:"cost,omitempty"`
+    Layers []layerJSON `json:"layers,omitempty"`
+    // Versions 1 to 3
+    NumLayers int `json:"num_layers,omitempty"`
+    Sizes []int `json:"sizes,omitempty"`
+    Activations []string `json:"activations,omitempty"`
+    Biases []matJSON `json:"biases,omitempty"`
+    Weights []matJSON `json:"weights,omitempty"`
+    Checksum uint32 `json:"checksum"`
+}
+
+// layerSpec is what gets saved of a layer: the kind of layer, the settings
+// needed to make it again and the matrices it holds
+type layerSpec struct {
+    Kind string
+    Shape []int
+    Activation string
+    Values []float64
+    Mats []*mottuMat.MottuMat
+}
+
+// savedLayer is implemented by the layers that can be saved
+type savedLayer interface {
+    spec() layerSpec
+}
+
+// Save writes the network to w in the binary model format. It returns
+// ErrUnsupported if a layer isn't one of the types defined in this package.
+func (this *mottuNet) Save(w io.Writer) error {
+    var body bytes.Buffer
+    if err := this.encodeBody(&body, modelVersion); err != nil {
+        return err
+    }
+
+    bw := bufio.NewWriter(w)
+    header := []uint32{modelMagic, modelVersion}
+    if err := binary.Write(bw, binary.BigEndian, header); err != nil {
+        return err
+    }
+    if _, err := bw.Write(body.Bytes()); err != nil {
+        return err
+    }
+    if err := binary.Write(bw, binary.BigEndian, crc32.ChecksumIEEE(body.Bytes())); err != nil {
+        return err
+    }
+    return bw.Flush()
+}
+
+// SaveJSON writes the network to w as JSON
+func (this *mottuNet) SaveJSON(w io.Writer) error {
+    var body bytes.Buffer
+    if err := this.encodeBody(&body, modelVersion); err != nil {
+        return err
+    }
+
+    m := modelJSON{
+        Version: modelVersion,
+        Cost: this.cost.Name(),
+        Layers: make([]layerJSON, len(this.model.layers)),
+        Checksum: crc32.ChecksumIEEE(body.Bytes()),
+    }
+    for i, l := range this.model.layers {
+        spec := l.(savedLayer).spec()
+        m.Layers[i] = layerJSON{
+            Kind: spec.Kind,
+            Shape: spec.Shape,
+            Activation: spec.Activation,
+            Values: spec.Values,
+            Mats: make([]matJSON, len(spec.Mats)),
+        }
+        for j, mat := range spec.Mats {
+            m.Layers[i].Mats[j] = toMatJSON(mat)
+        }
+    }
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(&m)
+}
+
+// SaveFile writes the network to the named file. Files ending in ".json"
+// are written as JSON, everything else uses the binary format.
+func (this *mottuNet) SaveFile(name string) error {
+    f, err := os.Create(name)
+    if err != nil {
+        return err
+    }
+    if isJSONName(name) {
+        err = this.SaveJSON(f)
+    } else {
+        err = this.Save(f)
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    return err
+}
+
+// Load reads a network written by Save, reading r to the end. The checksum
+// is checked before anything is decoded, and every size is checked against
+// the bytes left before anything is allocated for it, so a corrupt or
+// crafted file gives an error rather than a huge allocation.
+func Load(r io.Reader) (*mottuNet, error) {
+    br := bufio.NewReader(r)
+    var header [2]uint32
+    if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+        return nil, err
+    }
+    if header[0] != modelMagic {
+        return nil, ErrBadMagic
+    }
+    version := int(header[1])
+    if version < 1 || version > modelVersion {
+        return nil, fmt.Errorf("%w: %d", ErrBadVersion, version)
+    }
+
+    rest, err := io.ReadAll(br)
+    if err != nil {
+        return nil, err
+    }
+    if len(rest) < 4 {
+        return nil, fmt.Errorf("%w: %w", ErrCorrupt, io.ErrUnexpectedEOF)
+    }
+    body := rest[:len(rest)-4]
+    if binary.BigEndian.Uint32(rest[len(rest)-4:]) != crc32.ChecksumIEEE(body) {
+        return nil, ErrChecksum
+    }
+
+    br2 := bytes.NewReader(body)
+    var retval *mottuNet
+    if version >= 4 {
+        retval, err = decodeLayers(br2)
+    } else {
+        retval, err = decodeDense(br2, version)
+    }
+    if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return nil, fmt.Errorf("%w: %w", ErrCorrupt, io.ErrUnexpectedEOF)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if br2.Len() != 0 {
+        return nil, fmt.Errorf("%w: %d bytes after the last layer", ErrCorrupt, br2.Len())
+    }
+    return retval, nil
+}
+
+// decodeLayers reads the body of a version 4 model
+func decodeLayers(r *bytes.Reader) (*mottuNet, error) {
+    cost_name, err := readString(r)
+    if err != nil {
+        return nil, err
+    }
+    cost, err := ParseCost(cost_name)
+    if err != nil {
+        return nil, err
+    }
+    var num_layers uint32
+    if err := binary.Read(r, binary.BigEndian, &num_layers); err != nil {
+        return nil, err
+    }
+    if num_layers < 1 || num_layers > math.MaxInt16 {
+        return nil, fmt.Errorf("network: invalid layer count %d", num_layers)
+    }
+
+    layers := make([]Layer, num_layers)
+    for i := range layers {
+        var spec layerSpec
+        var err error
+        if spec.Kind, err = readString(r); err != nil {
+            return nil, err
+        }
+        var count uint16
+        if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+            return nil, err
+        }
+        raw_shape := make([]uint32, count)
+        if err := binary.Read(r, binary.BigEndian, raw_shape); err != nil {
+            return nil, err
+        }
+        for _, v := range raw_shape {
+            spec.Shape = append(spec.Shape, int(v))
+        }
+        if spec.Activation, err = readString(r); err != nil {
+            return nil, err
+        }
+        if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+            return nil, err
+        }
+        spec.Values = make([]float64, count)
+        if err := binary.Read(r, binary.BigEndian, spec.Values); err != nil {
+            return nil, err
+        }
+        if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+            return nil, err
+        }
+        spec.Mats = make([]*mottuMat.MottuMat, count)
+        for j := range spec.Mats {
+            if spec.Mats[j], err = readMat(r, -1, -1); err != nil {
+                return nil, fmt.Errorf("network: layer %d: %w", i, err)
+            }
+        }
+        if layers[i], err = layerFromSpec(spec); err != nil {
+            return nil, fmt.Errorf("network: layer %d: %w", i, err)
+        }
+    }
+    return netFromLayers(layers, cost)
+}
+
+// decodeDense reads the body of a version 1 to 3 model
+func decodeDense(r *bytes.Reader, version int) (*mottuNet, error) {
+    var num_layers uint32
+    if err := binary.Read(r, binary.BigEndian, &num_layers); err != nil {
+        return nil, err
+    }
+    if num_layers < 2 || num_layers > math.MaxInt16 {
+        return nil, fmt.Errorf("network: invalid layer count %d", num_layers)
+    }
+    raw_sizes := make([]uint32, num_layers)
+    if err := binary.Read(r, binary.BigEndian, raw_sizes); err != nil {
+        return nil, err
+    }
+    sizes := make([]int, num_layers)
+    for i := range raw_sizes {
+        sizes[i] = int(raw_sizes[i])
+    }
+    if err := checkSizes(sizes, r.Len()/8); err != nil {
+        return nil, err
+    }
+
+    retval := newDenseNet(sizes)
+    denses, acts, _ := retval.dense_stack()
+    if version >= 2 {
+        for i := 0; i < len(acts); i++ {
+            name, err := readString(r)
+            if err != nil {
+                return nil, err
+            }
+            if acts[i].act, err = ParseActivation(name); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if version >= 3 {
+        name, err := readString(r)
+        if err != nil {
+            return nil, err
+        }
+        if retval.cost, err = ParseCost(name); err != nil {
+            return nil, err
+        }
+    }
+    for i, d := range denses {
+        var err error
+        if d.biases, err = readMat(r, sizes[i+1], 1); err != nil {
+            return nil, fmt.Errorf("network: biases of layer %d: %w", i+1, err)
+        }
+        if d.weights, err = readMat(r, sizes[i+1], sizes[i]); err != nil {
+            return nil, fmt.Errorf("network: weights of layer %d: %w", i+1, err)
+        }
+    }
+    return retval, nil
+}
+
+// LoadJSON reads a network written by SaveJSON
+func LoadJSON(r io.Reader) (*mottuNet, error) {
+    var m modelJSON
+    if err := json.NewDecoder(r).Decode(&m); err != nil {
+        return nil, err
+    }
+    if m.Version < 1 || m.Version > modelVersion {
+        return nil, fmt.Errorf("%w: %d", ErrBadVersion, m.Version)
+    }
+    var retval *mottuNet
+    var err error
+    if m.Version >= 4 {
+        retval, err = layersFromJSON(&m)
+    } else {
+        retval, err = denseFromJSON(&m)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var body bytes.Buffer
+    if err := retval.encodeBody(&body, m.Version); err != nil {
+        return nil, err
+    }
+    if m.Checksum != crc32.ChecksumIEEE(body.Bytes()) {
+        return nil, ErrChecksum
+    }
+    return retval, nil
+}
+
+func layersFromJSON(m *modelJSON) (*mottuNet, error) {
+    cost, err := ParseCost(m.Cost)
+    if err != nil {
+        return nil, err
+    }
+    if len(m.Layers) == 0 {
+        return nil, fmt.Errorf("network: invalid layer count 0")
+    }
+    layers := make([]Layer, len(m.Layers))
+    for i, lj := range m.Layers {
+        spec := layerSpec{Kind: lj.Kind, Shape: lj.Shape, Activation: lj.Activation, Values: lj.Values}
+        for _, mj := range lj.Mats {
+            if mj.Rows < 0 || mj.Cols < 0 {
+                return nil, fmt.Errorf("network: layer %d: invalid shape %dx%d", i, mj.Rows, mj.Cols)
+            }
+            mat, err := fromMatJSON(mj, mj.Rows, mj.Cols)
+            if err != nil {
+                return nil, fmt.Errorf("network: layer %d: %w", i, err)
+            }
+            spec.Mats = append(spec.Mats, mat)
+        }
+        if layers[i], err = layerFromSpec(spec); err != nil {
+            return nil, fmt.Errorf("network: layer %d: %w", i, err)
+        }
+    }
+    return netFromLayers(layers, cost)
+}
+
+func denseFromJSON(m *modelJSON) (*mottuNet, error) {
+    if m.NumLayers != len(m.Sizes) {
+        return nil, fmt.Errorf("network: num_layers is %d but %d sizes given",
+                               m.NumLayers, len(m.Sizes))
+    }
+    values := 0
+    for _, mj := range append(m.Biases, m.Weights...) {
+        values += len(mj.Data)
+    }
+    if err := checkSizes(m.Sizes, values); err != nil {
+        return nil, err
+    }
+    if len(m.Biases) != m.NumLayers-1 || len(m.Weights) != m.NumLayers-1 {
+        return nil, fmt.Errorf("network: expected %d bias and weight matrices, got %d and %d",
+                               m.NumLayers-1, len(m.Biases), len(m.Weights))
+    }
+
+    retval := newDenseNet(m.Sizes)
+    denses, acts, _ := retval.dense_stack()
+    if m.Version >= 2 {
+        if len(m.Activations) != m.NumLayers-1 {
+            return nil, fmt.Errorf("network: expected %d activations, got %d",
+                                   m.NumLayers-1, len(m.Activations))
+        }
+        for i, name := range m.Activations {
+            var err error
+            if acts[i].act, err = ParseActivation(name); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if m.Version >= 3 {
+        var err error
+        if retval.cost, err = ParseCost(m.Cost); err != nil {
+            return nil, err
+        }
+    }
+    for i, d := range denses {
+        var err error
+        if d.biases, err = fromMatJSON(m.Biases[i], m.Sizes[i+1], 1); err != nil {
+            return nil, fmt.Errorf("network: biases of layer %d: %w", i+1, err)
+        }
+        if d.weights, err = fromMatJSON(m.Weights[i], m.Sizes[i+1], m.Sizes[i]); err != nil {
+            return nil, fmt.Errorf("network: weights of layer %d: %w", i+1, err)
+        }
+    }
+    return retval, nil
+}
+
+// LoadFile reads a network from the named file, picking the format the same
+// way SaveFile does
+func LoadFile(name string) (*mottuNet, error) {
+    f, err := os.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    if isJSONName(name) {
+        return LoadJSON(f)
+    }
+    return Load(f)
+}
+
+// ==================== layers ===============
+
+func (d *Dense) spec() layerSpec {
+    return layerSpec{
+        Kind: "dense",
+        Shape: []int{d.InputSize(), d.OutputSize()},
+        Mats: []*mottuMat.MottuMat{d.weights, d.biases},
+    }
+}
+
+func (l *ActivationLayer) spec() layerSpec {
+    return layerSpec{Kind: "activation", Shape: []int{l.size}, Activation: l.act.Name()}
+}
+
+func (d *Dropout) spec() layerSpec {
+    return layerSpec{Kind: "dropout", Shape: []int{d.size}, Values: []float64{d.keep}}
+}
+
+func (c *Conv2D) spec() layerSpec {
+    return layerSpec{
+        Kind: "conv2d",
+        Shape: []int{c.in.Channels, c.in.Height, c.in.Width, c.out.Channels, c.kernel, c.stride, c.padding},
+        Activation: c.act.Name(),
+        Mats: []*mottuMat.MottuMat{c.kernels, c.bias},
+    }
+}
+
+func (p *Pool) spec() layerSpec {
+    kind := "avgpool"
+    if p.max {
+        kind = "maxpool"
+    }
+    return layerSpec{Kind: kind, Shape: []int{p.in.Channels, p.in.Height, p.in.Width, p.size, p.stride}}
+}
+
+func (b *BatchNorm) spec() layerSpec {
+    return layerSpec{
+        Kind: "batchnorm",
+        Shape: []int{b.size},
+        Values: []float64{b.momentum},
+        Mats: []*mottuMat.MottuMat{b.gamma, b.beta, b.running_mean, b.running_var},
+    }
+}
+
+func (f *Flatten) spec() layerSpec {
+    return layerSpec{Kind: "flatten", Shape: []int{f.in.Channels, f.in.Height, f.in.Width}}
+}
+
+// layerFromSpec makes the layer a spec was taken from
+func layerFromSpec(spec layerSpec) (l Layer, err error) {
+    // The constructors panic on settings that make no sense
+    defer func() {
+        if p := recover(); p != nil {
+            l, err = nil, fmt.Errorf("invalid %s layer: %v", spec.Kind, p)
+        }
+    }()
+    shape_len := map[string]int{
+        "dense": 2, "activation": 1, "dropout": 1, "conv2d": 7,
+        "maxpool": 5, "avgpool": 5, "flatten": 3, "batchnorm": 1,
+    }
+    n, known := shape_len[spec.Kind]
+    if !known {
+        return nil, fmt.Errorf("unknown layer kind %q", spec.Kind)
+    }
+    if len(spec.Shape) != n {
+        return nil, fmt.Errorf("%s layer needs %d shape values, got %d", spec.Kind, n, len(spec.Shape))
+    }
+    for _, v := range spec.Shape {
+        if v < 0 || v > maxShape {
+            return nil, fmt.Errorf("%w: %s layer has invalid shape %v", ErrCorrupt, spec.Kind, spec.Shape)
+        }
+    }
+    var act Activation
+    if spec.Activation != "" {
+        if act, err = ParseActivation(spec.Activation); err != nil {
+            return nil, err
+        }
+    }
+    sh := spec.Shape
+    switch spec.Kind {
+    case "dense":
+        if err := checkMats(spec, mottuMat.Shape{Rows: sh[1], Cols: sh[0]}, mottuMat.Shape{Rows: sh[1], Cols: 1}); err != nil {
+            return nil, err
+        }
+        d := MakeDense(sh[0], sh[1])
+        return d, setMats(spec, d.weights, d.biases)
+    case "activation":
+        if act == nil {
+            return nil, errors.New("activation layer without an activation")
+        }
+        return MakeActivationLayer(sh[0], act), setMats(spec)
+    case "dropout":
+        if len(spec.Values) != 1 {
+            return nil, errors.New("dropout layer needs a keep probability")
+        }
+        return MakeDropout(sh[0], spec.Values[0]), setMats(spec)
+    case "conv2d":
+        if act == nil {
+            return nil, errors.New("conv2d layer without an activation")
+        }
+        if err := checkMats(spec, mottuMat.Shape{Rows: sh[3], Cols: sh[0]*sh[4]*sh[4]}, mottuMat.Shape{Rows: sh[3], Cols: 1}); err != nil {
+            return nil, err
+        }
+        c := make_conv2d(Volume{sh[0], sh[1], sh[2]}, sh[3], sh[4], sh[5], sh[6], act)
+        return c, setMats(spec, c.kernels, c.bias)
+    case "batchnorm":
+        if len(spec.Values) != 1 {
+            return nil, errors.New("batchnorm layer needs a momentum")
+        }
+        col := mottuMat.Shape{Rows: sh[0], Cols: 1}
+        if err := checkMats(spec, col, col, col, col); err != nil {
+            return nil, err
+        }
+        b := MakeBatchNorm(sh[0], spec.Values[0])
+        return b, setMats(spec, b.gamma, b.beta, b.running_mean, b.running_var)
+    case "maxpool", "avgpool":
+        p := make_pool(Volume{sh[0], sh[1], sh[2]}, sh[3], sh[4], spec.Kind == "maxpool")
+        return p, setMats(spec)
+    }
+    return MakeFlatten(Volume{sh[0], sh[1], sh[2]}), setMats(spec)
+}
+
+// checkMats checks that spec holds matrices of the given shapes. It is done
+// before making a layer, so the layer's matrices are never allocated for a
+// shape the file doesn't hold the values of.
+func checkMats(spec layerSpec, shapes ...mottuMat.Shape) error {
+    if len(spec.Mats) != len(shapes) {
+        return fmt.Errorf("%s layer holds %d matrices, got %d", spec.Kind, len(shapes), len(spec.Mats))
+    }
+    for i, m := range spec.Mats {
+        if m.Shape() != shapes[i] {
+            return fmt.Errorf("%s layer matrix %d is %v, expected %v", spec.Kind, i, m.Shape(), shapes[i])
+        }
+    }
+    return nil
+}
+
+// setMats copies the matrices of spec into dst, which have to be the same
+// number and shapes
+func setMats(spec layerSpec, dst ...*mottuMat.MottuMat) error {
+    if len(spec.Mats) != len(dst) {
+        return fmt.Errorf("%s layer holds %d matrices, got %d", spec.Kind, len(dst), len(spec.Mats))
+    }
+    for i, m := range spec.Mats {
+        if m.Shape() != dst[i].Shape() {
+            return fmt.Errorf("%s layer matrix %d is %v, expected %v", spec.Kind, i, m.Shape(), dst[i].Shape())
+        }
+        dst[i].CopyFrom(m)
+    }
+    return nil
+}
+
+// ==================== helpers ===============
+
+// newDenseNet makes a network with the layout MakeMottuNet gives sizes, with
+// default settings and all the weights and biases at 0
+func newDenseNet(sizes []int) *mottuNet {
+    var layers []Layer
+    for i := 0; i < len(sizes)-1; i++ {
+        layers = append(layers, MakeDense(sizes[i], sizes[i+1]), MakeActivationLayer(sizes[i+1], Sigmoid))
+    }
+    return MakeMottuNetFromLayers(layers...)
+}
+
+// netFromLayers checks that loaded layers fit together before making a
+// network of them
+func netFromLayers(layers []Layer, cost Cost) (*mottuNet, error) {
+    for i := 1; i < len(layers); i++ {
+        if layers[i].InputSize() != layers[i-1].OutputSize() {
+            return nil, fmt.Errorf("network: layer %d takes %d inputs but layer %d outputs %d",
+                                   i, layers[i].InputSize(), i-1, layers[i-1].OutputSize())
+        }
+    }
+    retval := MakeMottuNetFromLayers(layers...)
+    retval.cost = cost
+    return retval, nil
+}
+
+// dense_stack returns the Dense layers and their activations when the
+// network is laid out the way MakeMottuNet does it, which is all versions
+// before 4 can store
+func (this *mottuNet) dense_stack() ([]*Dense, []*ActivationLayer, bool) {
+    layers := this.model.layers
+    if len(layers)%2 != 0 {
+        return nil, nil, false
+    }
+    var denses []*Dense
+    var acts []*ActivationLayer
+    for i := 0; i < len(layers); i += 2 {
+        d, is_dense := layers[i].(*Dense)
+        a, is_act := layers[i+1].(*ActivationLayer)
+        if !is_dense || !is_act {
+            return nil, nil, false
+        }
+        denses = append(denses, d)
+        acts = append(acts, a)
+    }
+    return denses, acts, true
+}
+
+// checkSizes checks the layer sizes of a version 1 to 3 model, whose
+// weights and biases have to fit in the values the file holds
+func checkSizes(sizes []int, values int) error {
+    if len(sizes) < 2 {
+        return fmt.Errorf("network: need at least 2 layers, got %d", len(sizes))
+    }
+    for i, s := range sizes {
+        if s <= 0 || s > maxShape {
+            return fmt.Errorf("%w: layer %d has invalid size %d", ErrCorrupt, i, s)
+        }
+    }
+    params := 0
+    for i := 1; i < len(sizes); i++ {
+        params += sizes[i]*(sizes[i-1]+1)
+        if params > values {
+            return fmt.Errorf("%w: layer sizes %v need more values than the %d stored", ErrCorrupt, sizes, values)
+        }
+    }
+    return nil
+}
+
+// encodeBody writes the part of the model covered by the checksum, laid out
+// as the given format version
+func (this *mottuNet) encodeBody(w io.Writer, version int) error {
+    // Writes to a bytes.Buffer can't fail
+    if version < 4 {
+        denses, acts, ok := this.dense_stack()
+        if !ok {
+            return fmt.Errorf("%w in version %d", ErrUnsupported, version)
+        }
+        binary.Write(w, binary.BigEndian, uint32(len(denses)+1))
+        binary.Write(w, binary.BigEndian, uint32(denses[0].InputSize()))
+        for _, d := range denses {
+            binary.Write(w, binary.BigEndian, uint32(d.OutputSize()))
+        }
+        if version >= 2 {
+            for _, a := range acts {
+                writeString(w, a.act.Name())
+            }
+        }
+        if version >= 3 {
+            writeString(w, this.cost.Name())
+        }
+        for _, d := range denses {
+            writeMat(w, d.biases)
+            writeMat(w, d.weights)
+        }
+        return nil
+    }
+
+    writeString(w, this.cost.Name())
+    binary.Write(w, binary.BigEndian, uint32(len(this.model.layers)))
+    for _, l := range this.model.layers {
+        sl, ok := l.(savedLayer)
+        if !ok {
+            return fmt.Errorf("%w: %T", ErrUnsupported, l)
+        }
+        spec := sl.spec()
+        writeString(w, spec.Kind)
+        binary.Write(w, binary.BigEndian, uint16(len(spec.Shape)))
+        for _, v := range spec.Shape {
+            binary.Write(w, binary.BigEndian, uint32(v))
+        }
+        writeString(w, spec.Activation)
+        binary.Write(w, binary.BigEndian, uint16(len(spec.Values)))
+        binary.Write(w, binary.BigEndian, spec.Values)
+        binary.Write(w, binary.BigEndian, uint16(len(spec.Mats)))
+        for _, m := range spec.Mats {
+            writeMat(w, m)
+        }
+    }
+    return nil
+}
+
+func writeString(w io.Writer, str string) {
+    binary.Write(w, binary.BigEndian, uint16(len(str)))
+    io.WriteString(w, str)
+}
+
+func readString(r io.Reader) (string, error) {
+    var n uint16
+    if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+        return "", err
+    }
+    buf := make([]byte, n)
+    if _, err := io.ReadFull(r, buf); err != nil {
+        return "", err
+    }
+    return string(buf), nil
+}
+
+func writeMat(w io.Writer, m *mottuMat.MottuMat) {
+    binary.Write(w, binary.BigEndian, uint32(m.Rows()))
+    binary.Write(w, binary.BigEndian, uint32(m.Cols()))
+    for i := 0; i < m.Rows(); i++ {
+        for j := 0; j < m.Cols(); j++ {
+            binary.Write(w, binary.BigEndian, m.GetElem(i, j))
+        }
+    }
+}
+
+// readMat reads a matrix written by writeMat. A rows and cols of -1 take
+// whatever shape was written, as long as r holds that many values.
+func readMat(r *bytes.Reader, rows, cols int) (*mottuMat.MottuMat, error) {
+    var shape [2]uint32
+    if err := binary.Read(r, binary.BigEndian, &shape); err != nil {
+        return nil, err
+    }
+    if rows < 0 && cols < 0 {
+        rows, cols = int(shape[0]), int(shape[1])
+    }
+    if int(shape[0]) != rows || int(shape[1]) != cols {
+        return nil, fmt.Errorf("shape is %dx%d, expected %dx%d", shape[0], shape[1], rows, cols)
+    }
+    if uint64(rows)*uint64(cols) > uint64(r.Len()/8) {
+        return nil, fmt.Errorf("%w: %dx%d matrix but only %d bytes left", ErrCorrupt, rows, cols, r.Len())
+    }
+    data := make([]float64, rows*cols)
+    if err := binary.Read(r, binary.BigEndian, data); err != nil {
+        return nil, err
+    }
+    m := mottuMat.MakeMat(rows, cols)
+    for i := 0; i < rows; i++ {
+        for j := 0; j < cols; j++ {
+            m.SetElem(i, j, data[i*cols+j])
+        }
+    }
+    return m, nil
+}
+
+func toMatJSON(m *mottuMat.MottuMat) matJSON {
+    retval := matJSON{Rows: m.Rows(), Cols: m.Cols(), Data: make([]float64, 0, m.Rows()*m.Cols())}
+    for i := 0; i < m.Rows(); i++ {
+        for j := 0; j < m.Cols(); j++ {
+            retval.Data = append(retval.Data, m.GetElem(i, j))
+        }
+    }
+    return retval
+}
+
+func fromMatJSON(mj matJSON, rows, cols int) (*mottuMat.MottuMat, error) {
+    if mj.Rows != rows || mj.Cols != cols {
+        return nil, fmt.Errorf("shape is %dx%d, expected %dx%d", mj.Rows, mj.Cols, rows, cols)
+    }
+    if rows < 0 || cols < 0 || rows > math.MaxInt32 || cols > math.MaxInt32 || len(mj.Data) != rows*cols {
+        return nil, fmt.Errorf("has %d values, expected %d", len(mj.Data), rows*cols)
+    }
+    m := mottuMat.MakeMat(rows, cols)
+    for i := 0; i < rows; i++ {
+        for j := 0; j < cols; j++ {
+            m.SetElem(i, j, mj.Data[i*cols+j])
+        }
+    }
+    return m, nil
+}
+
+func isJSONName(name string) bool {
+    return strings.EqualFold(filepath.Ext(name), ".json")
+}
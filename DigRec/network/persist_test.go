@@ -0,0 +1,186 @@
+package network
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// test_net returns a small network with random weights
+func test_net() *mottuNet {
+    mn := MakeMottuNetWithActivations([]int{5, 4, 3}, []Activation{Tanh, Sigmoid})
+    mn.Initialize(StandardNormal, rand.New(rand.NewSource(3)))
+    mn.SetCost(CrossEntropy)
+    return mn
+}
+
+// model_file puts the header and checksum around an encoded body
+func model_file(version int, body []byte) []byte {
+    var buf bytes.Buffer
+    binary.Write(&buf, binary.BigEndian, []uint32{modelMagic, uint32(version)})
+    buf.Write(body)
+    binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(body))
+    return buf.Bytes()
+}
+
+func same_output(t *testing.T, a, b *mottuNet) {
+    t.Helper()
+    x := mottuMat.MakeColVec(a.InputSize())
+    for i := 0; i < x.Rows(); i++ {
+        x.SetElem(i, 0, float64(i)/float64(x.Rows()) - 0.5)
+    }
+    out_a, out_b := a.FeedForward(x), b.FeedForward(x)
+    for i := 0; i < out_a.Rows(); i++ {
+        if out_a.GetElem(i, 0) != out_b.GetElem(i, 0) {
+            t.Fatalf("output %d is %g after loading, was %g", i, out_b.GetElem(i, 0), out_a.GetElem(i, 0))
+        }
+    }
+    if a.Cost() != b.Cost() {
+        t.Fatalf("cost is %s after loading, was %s", b.Cost().Name(), a.Cost().Name())
+    }
+}
+
+func TestSaveLoad(t *testing.T) {
+    mn := test_net()
+    var buf bytes.Buffer
+    if err := mn.Save(&buf); err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := Load(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    same_output(t, mn, loaded)
+
+    buf.Reset()
+    if err := mn.SaveJSON(&buf); err != nil {
+        t.Fatal(err)
+    }
+    if loaded, err = LoadJSON(&buf); err != nil {
+        t.Fatal(err)
+    }
+    same_output(t, mn, loaded)
+}
+
+func TestLoadOldVersions(t *testing.T) {
+    mn := test_net()
+    for version := 1; version < modelVersion; version++ {
+        var body bytes.Buffer
+        if err := mn.encodeBody(&body, version); err != nil {
+            t.Fatal(err)
+        }
+        loaded, err := Load(bytes.NewReader(model_file(version, body.Bytes())))
+        if err != nil {
+            t.Fatalf("version %d: %v", version, err)
+        }
+        // Version 1 has no activations and versions before 3 no cost
+        if version >= 3 {
+            same_output(t, mn, loaded)
+        }
+    }
+}
+
+func TestLoadCorrupt(t *testing.T) {
+    var buf bytes.Buffer
+    if err := test_net().Save(&buf); err != nil {
+        t.Fatal(err)
+    }
+    file := buf.Bytes()
+
+    for i := 8; i < len(file); i += 7 {
+        bad := append([]byte(nil), file...)
+        bad[i] ^= 0x40
+        if _, err := Load(bytes.NewReader(bad)); !errors.Is(err, ErrChecksum) {
+            t.Errorf("flipping byte %d: got %v, want ErrChecksum", i, err)
+        }
+    }
+    for n := 0; n < len(file); n += 5 {
+        if _, err := Load(bytes.NewReader(file[:n])); err == nil {
+            t.Errorf("loading the first %d of %d bytes succeeded", n, len(file))
+        }
+    }
+    if _, err := Load(bytes.NewReader([]byte("not a model at all"))); !errors.Is(err, ErrBadMagic) {
+        t.Errorf("got %v, want ErrBadMagic", err)
+    }
+}
+
+// A file with a valid checksum claiming huge layers has to be refused
+// before anything is allocated for them
+func TestLoadOversizedDense(t *testing.T) {
+    for _, sizes := range [][]uint32{{math.MaxUint32, 10}, {1 << 20, 1 << 20}, {784, 30, 10}} {
+        var body bytes.Buffer
+        binary.Write(&body, binary.BigEndian, uint32(len(sizes)))
+        binary.Write(&body, binary.BigEndian, sizes)
+        for range sizes[1:] {
+            writeString(&body, "sigmoid")
+        }
+        writeString(&body, "quadratic")
+        binary.Write(&body, binary.BigEndian, []uint32{sizes[1], 1})
+        _, err := Load(bytes.NewReader(model_file(3, body.Bytes())))
+        if !errors.Is(err, ErrCorrupt) {
+            t.Errorf("sizes %v: got %v, want ErrCorrupt", sizes, err)
+        }
+    }
+}
+
+// v4_body encodes a model of a single dense layer, with the shape and the
+// matrix header given
+func v4_body(shape []uint32, rows, cols uint32, values int) []byte {
+    var body bytes.Buffer
+    writeString(&body, "quadratic")
+    binary.Write(&body, binary.BigEndian, uint32(1))
+    writeString(&body, "dense")
+    binary.Write(&body, binary.BigEndian, uint16(len(shape)))
+    binary.Write(&body, binary.BigEndian, shape)
+    writeString(&body, "")
+    binary.Write(&body, binary.BigEndian, uint16(0))
+    binary.Write(&body, binary.BigEndian, uint16(2))
+    binary.Write(&body, binary.BigEndian, []uint32{rows, cols})
+    binary.Write(&body, binary.BigEndian, make([]float64, values))
+    binary.Write(&body, binary.BigEndian, []uint32{shape[1], 1})
+    binary.Write(&body, binary.BigEndian, make([]float64, shape[1]))
+    return body.Bytes()
+}
+
+func TestLoadLayersCorrupt(t *testing.T) {
+    if _, err := Load(bytes.NewReader(model_file(4, v4_body([]uint32{3, 2}, 2, 3, 6)))); err != nil {
+        t.Fatalf("well formed file: %v", err)
+    }
+
+    tests := []struct {
+        name string
+        body []byte
+    }{
+        {"oversized matrix", v4_body([]uint32{3, 2}, math.MaxUint32, math.MaxUint32, 6)},
+        {"matrix larger than the file", v4_body([]uint32{3, 2}, 1000, 1000, 6)},
+        {"oversized shape", v4_body([]uint32{math.MaxUint32, 2}, 2, 3, 6)},
+        {"shape without its values", v4_body([]uint32{1 << 20, 1 << 20}, 2, 3, 6)},
+        {"truncated", v4_body([]uint32{3, 2}, 2, 3, 6)[:40]},
+    }
+    for _, test := range tests {
+        // The checksum is right, so only the sizes can give the file away
+        _, err := Load(bytes.NewReader(model_file(4, test.body)))
+        if err == nil {
+            t.Errorf("%s: loaded", test.name)
+        }
+    }
+    _, err := Load(bytes.NewReader(model_file(4, tests[0].body)))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Errorf("oversized matrix: got %v, want ErrCorrupt", err)
+    }
+    _, err = Load(bytes.NewReader(model_file(4, tests[4].body)))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Errorf("truncated: got %v, want ErrCorrupt", err)
+    }
+
+    json := `{"version": 4, "cost": "quadratic", "layers": [{"kind": "dense", "shape": [1048576, 1048576],
+              "mats": [{"rows": 1, "cols": 1, "data": [0]}, {"rows": 1, "cols": 1, "data": [0]}]}]}`
+    if _, err := LoadJSON(bytes.NewReader([]byte(json))); err == nil {
+        t.Error("JSON with a shape its matrices don't hold loaded")
+    }
+}
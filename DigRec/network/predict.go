@@ -0,0 +1,146 @@
+package network
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "math"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+// ErrInputShape is returned when images can't be fed to a network because
+// its input isn't a single square image
+var ErrInputShape = errors.New("network: input isn't a square gray image")
+
+// ClassProb is a class along with the probability the network gives it
+type ClassProb struct {
+    Class int
+    Probability float64
+}
+
+// Prediction is what the network makes of one sample
+type Prediction struct {
+    Digit int // the most likely class
+    // Probabilities has one entry per class, adding up to 1
+    Probabilities []float64
+    // Top holds the k most likely classes, most likely first
+    Top []ClassProb
+}
+
+// Predict classifies an image. A mnist.RawImage is taken as it is; any
+// other image, such as a photo or drawing of a digit, first goes through
+// mnist.Preprocess to look like the MNIST digits: transparent pixels become
+// paper, dark digits on a light background are inverted, and the digit is
+// scaled and centered; pictures over mnist.MaxPixels are refused. Either
+// way it is then resized to the network's input, see mnist.ImageVec. k is
+// the number of classes returned in Prediction.Top.
+func (this *mottuNet) Predict(img image.Image, k int) (*Prediction, error) {
+    predictions, err := this.PredictBatch([]image.Image{img}, k)
+    if err != nil {
+        return nil, err
+    }
+    return predictions[0], nil
+}
+
+// PredictBatch is Predict for several images, run through the network as
+// one batch
+func (this *mottuNet) PredictBatch(imgs []image.Image, k int) ([]*Prediction, error) {
+    if len(imgs) == 0 {
+        return nil, nil
+    }
+    width, height, err := this.ImageSize()
+    if err != nil {
+        return nil, err
+    }
+    vecs := make([]*mottuMat.MottuMat, len(imgs))
+    for i, img := range imgs {
+        if _, ok := img.(mnist.RawImage); !ok {
+            if img, err = mnist.Preprocess(img); err != nil {
+                return nil, err
+            }
+        }
+        vecs[i] = mnist.ImageVec(img, width, height)
+    }
+    return this.PredictMat(mottuMat.HStack(vecs), k)
+}
+
+// PredictMat classifies every column of x, which are samples in the format
+// of mnist.Set.Images
+func (this *mottuNet) PredictMat(x *mottuMat.MottuMat, k int) ([]*Prediction, error) {
+    classes := this.model.OutputSize()
+    if k < 1 || k > classes {
+        return nil, fmt.Errorf("network: top-k needs 1 <= k <= %d, got %d", classes, k)
+    }
+    if x.Rows() != this.InputSize() {
+        return nil, &mottuMat.ShapeError{
+            Op: "Predict",
+            A: mottuMat.Shape{Rows: this.InputSize(), Cols: x.Cols()},
+            B: x.Shape(),
+        }
+    }
+    out, _ := this.model.Forward(x, false, nil)
+    probs := this.probabilities(out)
+    predictions := make([]*Prediction, x.Cols())
+    for j := range predictions {
+        col := mottuMat.MakeColVec(classes)
+        p := &Prediction{Probabilities: make([]float64, classes)}
+        for i := 0; i < classes; i++ {
+            p.Probabilities[i] = probs.GetElem(i, j)
+            col.SetElem(i, 0, p.Probabilities[i])
+        }
+        for _, c := range top_k(col, k) {
+            p.Top = append(p.Top, ClassProb{c, p.Probabilities[c]})
+        }
+        p.Digit = p.Top[0].Class
+        predictions[j] = p
+    }
+    return predictions, nil
+}
+
+// ImageSize returns the width and height of the images the network takes:
+// the input volume of a leading convolution or pooling layer with a single
+// channel, otherwise the square the input size makes
+func (this *mottuNet) ImageSize() (int, int, error) {
+    switch l := this.model.layers[0].(type) {
+    case *Conv2D:
+        if l.in.Channels != 1 {
+            return 0, 0, ErrInputShape
+        }
+        return l.in.Width, l.in.Height, nil
+    case *Pool:
+        if l.in.Channels != 1 {
+            return 0, 0, ErrInputShape
+        }
+        return l.in.Width, l.in.Height, nil
+    }
+    side := int(math.Round(math.Sqrt(float64(this.InputSize()))))
+    if side*side != this.InputSize() {
+        return 0, 0, ErrInputShape
+    }
+    return side, side, nil
+}
+
+// probabilities turns the output activations into one distribution per
+// column. Softmax outputs already are one; other outputs are divided by
+// their sum when none is negative, and go through softmax otherwise.
+func (this *mottuNet) probabilities(out *mottuMat.MottuMat) *mottuMat.MottuMat {
+    if al, ok := this.model.layers[len(this.model.layers)-1].(*ActivationLayer); ok && al.act == Softmax {
+        return out
+    }
+    probs := Softmax.Value(out)
+    for j := 0; j < out.Cols(); j++ {
+        sum, min := 0.0, math.Inf(1)
+        for i := 0; i < out.Rows(); i++ {
+            sum += out.GetElem(i, j)
+            min = math.Min(min, out.GetElem(i, j))
+        }
+        if min < 0 || sum <= 0 {
+            continue
+        }
+        for i := 0; i < out.Rows(); i++ {
+            probs.SetElem(i, j, out.GetElem(i, j)/sum)
+        }
+    }
+    return probs
+}
@@ -0,0 +1,174 @@
+package network
+
+import (
+    "errors"
+    "image"
+    "image/color"
+    "math"
+    "math/rand"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+)
+
+func predict_net() *mottuNet {
+    mn := MakeMottuNetWithActivations([]int{mnist.Width*mnist.Height, 10}, []Activation{Softmax})
+    mn.Initialize(ScaledNormal, rand.New(rand.NewSource(4)))
+    return mn
+}
+
+// bar_image returns a picture of a slanted stroke: dark on white when
+// transparent is false, dark on a transparent background otherwise
+func bar_image(transparent bool) image.Image {
+    img := image.NewNRGBA(image.Rect(0, 0, 50, 60))
+    for y := 0; y < 60; y++ {
+        for x := 0; x < 50; x++ {
+            c := color.NRGBA{255, 255, 255, 255}
+            if transparent {
+                c = color.NRGBA{0, 0, 0, 0}
+            }
+            if y >= 10 && y < 50 && x >= 15+y/4 && x < 22+y/4 {
+                c = color.NRGBA{20, 20, 20, 255}
+            }
+            img.SetNRGBA(x, y, c)
+        }
+    }
+    return img
+}
+
+func same_prediction(t *testing.T, what string, got, want *Prediction) {
+    t.Helper()
+    if got.Digit != want.Digit || len(got.Top) != len(want.Top) {
+        t.Fatalf("%s: predicted %d with top %v, want %d with top %v", what, got.Digit, got.Top, want.Digit, want.Top)
+    }
+    for i := range want.Probabilities {
+        if math.Abs(got.Probabilities[i] - want.Probabilities[i]) > 1e-12 {
+            t.Fatalf("%s: probability %d is %g, want %g", what, i, got.Probabilities[i], want.Probabilities[i])
+        }
+    }
+}
+
+func preprocessed(img image.Image) *mottuMat.MottuMat {
+    raw, _ := mnist.Preprocess(img)
+    return mnist.ImageVec(raw, mnist.Width, mnist.Height)
+}
+
+// Pictures go through mnist.Preprocess, like they do in the server and CLI
+func TestPredictPreprocesses(t *testing.T) {
+    mn := predict_net()
+    img := bar_image(false)
+    want, err := mn.PredictMat(preprocessed(img), 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := mn.Predict(img, 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    same_prediction(t, "dark on white", got, want[0])
+
+    // A transparent background is paper, not black
+    if got, err = mn.Predict(bar_image(true), 3); err != nil {
+        t.Fatal(err)
+    }
+    same_prediction(t, "dark on transparent", got, want[0])
+
+    // The stroke is inverted to be bright, as in MNIST
+    vec := preprocessed(img)
+    if vec.GetElem(0, 0) != 0 || vec.GetElem(14*mnist.Width+14, 0) < 0.5 {
+        t.Errorf("preprocessed corner %g and center %g, want the background 0 and the stroke bright",
+                 vec.GetElem(0, 0), vec.GetElem(14*mnist.Width+14, 0))
+    }
+}
+
+// A mnist.RawImage is already laid out like the training images and is fed
+// as it is
+func TestPredictRawImage(t *testing.T) {
+    mn := predict_net()
+    raw := make(mnist.RawImage, mnist.Width*mnist.Height)
+    for i := range raw {
+        raw[i] = uint8(i*7)
+    }
+    x := mottuMat.MakeColVec(len(raw))
+    for i, v := range raw {
+        x.SetElem(i, 0, float64(v)/255)
+    }
+    want, _ := mn.PredictMat(x, 10)
+    got, err := mn.PredictBatch([]image.Image{raw, bar_image(false)}, 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 2 {
+        t.Fatalf("%d predictions for 2 images", len(got))
+    }
+    same_prediction(t, "raw image", got[0], want[0])
+    sum := 0.0
+    for i, c := range got[1].Top {
+        sum += c.Probability
+        if i > 0 && c.Probability > got[1].Top[i-1].Probability {
+            t.Errorf("top isn't sorted: %v", got[1].Top)
+        }
+    }
+    if math.Abs(sum-1) > 1e-12 || got[1].Top[0].Class != got[1].Digit {
+        t.Errorf("top %v adds up to %g for digit %d", got[1].Top, sum, got[1].Digit)
+    }
+}
+
+func TestPredictErrors(t *testing.T) {
+    mn := predict_net()
+    for _, k := range []int{0, 11} {
+        if _, err := mn.Predict(bar_image(false), k); err == nil {
+            t.Errorf("k = %d: no error", k)
+        }
+    }
+    if _, err := MakeMottuNet([]int{10, 3}).Predict(bar_image(false), 1); !errors.Is(err, ErrInputShape) {
+        t.Errorf("10 inputs: got %v, want ErrInputShape", err)
+    }
+    var shape_err *mottuMat.ShapeError
+    if _, err := mn.PredictMat(mottuMat.MakeColVec(5), 1); !errors.As(err, &shape_err) {
+        t.Errorf("5 rows: got %v, want a *mottuMat.ShapeError", err)
+    }
+    if p, err := mn.PredictBatch(nil, 1); p != nil || err != nil {
+        t.Errorf("no images: got %v, %v", p, err)
+    }
+}
+
+func TestImageSize(t *testing.T) {
+    conv := MakeConv2D(Volume{1, 12, 10}, 2, 3, 1, 0, ReLU, rand.New(rand.NewSource(1)))
+    flatten := MakeFlatten(conv.OutputVolume())
+    tests := []struct {
+        net *mottuNet
+        w, h int
+    }{
+        {MakeMottuNet([]int{784, 10}), 28, 28},
+        {MakeMottuNet([]int{64, 10}), 8, 8},
+        {MakeMottuNetFromLayers(conv, flatten, MakeDense(flatten.OutputSize(), 10)), 10, 12},
+    }
+    for _, test := range tests {
+        w, h, err := test.net.ImageSize()
+        if err != nil || w != test.w || h != test.h {
+            t.Errorf("got %dx%d (%v), want %dx%d", w, h, err, test.w, test.h)
+        }
+    }
+}
+
+// Outputs that aren't softmax are normalized, through softmax when some
+// are negative
+func TestProbabilities(t *testing.T) {
+    mn := MakeMottuNetWithActivations([]int{2, 3}, []Activation{Identity})
+    out := mottuMat.MakeMat(3, 2)
+    for i, v := range []float64{1, 2, 1, -1, 0, 1} {
+        out.SetElem(i%3, i/3, v)
+    }
+    probs := mn.probabilities(out)
+    want := []float64{0.25, 0.5, 0.25}
+    e := []float64{math.Exp(-1), 1, math.E}
+    for i := 0; i < 3; i++ {
+        if math.Abs(probs.GetElem(i, 0) - want[i]) > 1e-12 {
+            t.Errorf("positive outputs: probability %d is %g, want %g", i, probs.GetElem(i, 0), want[i])
+        }
+        if soft := e[i]/(e[0]+e[1]+e[2]); math.Abs(probs.GetElem(i, 1) - soft) > 1e-12 {
+            t.Errorf("negative outputs: probability %d is %g, want %g", i, probs.GetElem(i, 1), soft)
+        }
+    }
+}
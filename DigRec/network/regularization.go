@@ -0,0 +1,61 @@
+package network
+
+// SetRegularization sets the L1 and L2 regularization parameters lambda.
+// Training then minimizes
+//
+//     C = C0 + l1/n sum |w| + l2/2n sum w^2
+//
+// where C0 is the unregularized cost and n is the size of the training set.
+// Biases are never regularized. Both default to 0.
+func (this *mottuNet) SetRegularization(l1, l2 float64) {
+    this.l1 = l1
+    this.l2 = l2
+}
+
+// regularization_cost returns the regularization term of the cost for a
+// data set of n samples
+func (this *mottuNet) regularization_cost(n int) float64 {
+    if (this.l1 == 0 && this.l2 == 0) || n == 0 {
+        return 0
+    }
+    sum_abs, sum_sq := 0.0, 0.0
+    for _, p := range this.params() {
+        if p.IsWeight {
+            sum_abs += p.Value.SumAbs()
+            sum_sq += p.Value.SumSquares()
+        }
+    }
+    return this.l1/float64(n)*sum_abs + 0.5*this.l2/float64(n)*sum_sq
+}
+
+// regularize adds the gradient of the regularization term for a training
+// set of n samples to the gradient of every weight:
+//
+//     g -> g + l1/n sgn(w) + l2/n w
+func (this *mottuNet) regularize(params []Param, n int) {
+    if (this.l1 == 0 && this.l2 == 0) || n == 0 {
+        return
+    }
+    for _, p := range params {
+        if !p.IsWeight {
+            continue
+        }
+        if this.l1 != 0 {
+            p.Grad.AddEq(p.Value.ApplyFunc(sign).Scale(this.l1/float64(n)))
+        }
+        if this.l2 != 0 {
+            p.Grad.AddEq(p.Value.Scale(this.l2/float64(n)))
+        }
+    }
+}
+
+func sign(x float64) float64 {
+    switch {
+    case x > 0:
+        return 1
+    case x < 0:
+        return -1
+    }
+    return 0
+}
+
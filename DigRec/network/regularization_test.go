@@ -0,0 +1,99 @@
+package network
+
+import (
+    "math"
+    "testing"
+)
+
+// reg_net returns a small network with known, nonzero parameters
+func reg_net(l1, l2 float64) *mottuNet {
+    mn := MakeMottuNet([]int{3, 2, 2})
+    mn.SetRegularization(l1, l2)
+    v := 0.3
+    for _, p := range mn.params() {
+        for r := 0; r < p.Value.Rows(); r++ {
+            for c := 0; c < p.Value.Cols(); c++ {
+                p.Value.SetElem(r, c, v)
+                v = -1.7*v + 0.1
+            }
+        }
+    }
+    return mn
+}
+
+// The cost is l1/n sum |w| + l2/2n sum w^2 over the weights only
+func TestRegularizationCost(t *testing.T) {
+    const n = 7
+    for _, test := range []struct{ l1, l2 float64 }{{0, 0}, {0.4, 0}, {0, 0.6}, {0.4, 0.6}} {
+        mn := reg_net(test.l1, test.l2)
+        want := 0.0
+        for _, p := range mn.params() {
+            for r := 0; r < p.Value.Rows(); r++ {
+                for c := 0; c < p.Value.Cols(); c++ {
+                    w := p.Value.GetElem(r, c)
+                    if p.IsWeight {
+                        want += test.l1/n*math.Abs(w) + test.l2/(2*n)*w*w
+                    }
+                }
+            }
+        }
+        if got := mn.regularization_cost(n); math.Abs(got - want) > 1e-12 {
+            t.Errorf("l1 %g l2 %g: cost %g, want %g", test.l1, test.l2, got, want)
+        }
+        if got := mn.regularization_cost(0); got != 0 {
+            t.Errorf("l1 %g l2 %g: cost %g for an empty set", test.l1, test.l2, got)
+        }
+    }
+}
+
+// regularize adds the derivative of regularization_cost to the weight
+// gradients and leaves the bias gradients alone
+func TestRegularizeGradient(t *testing.T) {
+    const n, h = 5, 1e-6
+    mn := reg_net(0.4, 0.6)
+    params := mn.params()
+    mn.regularize(params, n)
+    for i, p := range params {
+        for r := 0; r < p.Value.Rows(); r++ {
+            for c := 0; c < p.Value.Cols(); c++ {
+                w := p.Value.GetElem(r, c)
+                p.Value.SetElem(r, c, w+h)
+                plus := mn.regularization_cost(n)
+                p.Value.SetElem(r, c, w-h)
+                minus := mn.regularization_cost(n)
+                p.Value.SetElem(r, c, w)
+
+                want := (plus - minus)/(2*h)
+                if got := p.Grad.GetElem(r, c); math.Abs(got - want) > 1e-6 {
+                    t.Errorf("param %d (%d, %d): gradient %g, want %g", i, r, c, got, want)
+                }
+                if !p.IsWeight && p.Grad.GetElem(r, c) != 0 {
+                    t.Errorf("bias %d (%d, %d) was regularized", i, r, c)
+                }
+            }
+        }
+    }
+}
+
+// TotalCost regularizes by the size of the training set once the network
+// is trained, and by the size of the data before
+func TestTotalCostRegularization(t *testing.T) {
+    train, test := test_set(30, 3, 2, 1), test_set(10, 3, 2, 2)
+    mn := reg_net(0, 0.5)
+    unregularized := func() float64 {
+        mn.SetRegularization(0, 0)
+        defer mn.SetRegularization(0, 0.5)
+        return mn.TotalCost(test)
+    }
+
+    want := unregularized() + mn.regularization_cost(test.Count())
+    if got := mn.TotalCost(test); math.Abs(got - want) > 1e-12 {
+        t.Errorf("untrained: cost %g, want %g", got, want)
+    }
+    mn.SetHooks(&record_hooks{})
+    mn.SGD(train, 1, 10, 0.1)
+    want = unregularized() + mn.regularization_cost(train.Count())
+    if got := mn.TotalCost(test); math.Abs(got - want) > 1e-12 {
+        t.Errorf("trained: cost %g, want %g", got, want)
+    }
+}
@@ -0,0 +1,239 @@
+package network
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Progress says how far along training is when a learning rate is needed
+type Progress struct {
+    Epoch int
+    Batch int // mini batch within the epoch, starting at 0
+    BatchesPerEpoch int
+}
+
+// Epochs returns the number of epochs done so far, counting partial ones
+func (p Progress) Epochs() float64 {
+    if p.BatchesPerEpoch == 0 {
+        return float64(p.Epoch)
+    }
+    return float64(p.Epoch) + float64(p.Batch)/float64(p.BatchesPerEpoch)
+}
+
+// Schedule varies the learning rate over the course of training
+type Schedule interface {
+    // Rate returns the learning rate for the mini batch at p, given the
+    // eta passed to SGD
+    Rate(eta float64, p Progress) float64
+}
+
+// MetricObserver is implemented by schedules that adapt to how training is
+// going. SGD calls Observe with the validation accuracy after every epoch
+// when a validation set is given.
+type MetricObserver interface {
+    Observe(accuracy float64)
+}
+
+// SetSchedule sets the learning rate schedule used by SGD. nil, the
+// default, keeps eta fixed.
+func (this *mottuNet) SetSchedule(s Schedule) {
+    this.schedule = s
+}
+
+// ==================== Step and exponential decay ===============
+
+type stepDecay struct {
+    factor float64
+    every int
+}
+
+// MakeStepDecay multiplies the learning rate by factor every `every` epochs
+func MakeStepDecay(factor float64, every int) Schedule {
+    if every <= 0 {
+        every = 1
+    }
+    return stepDecay{factor, every}
+}
+
+func (s stepDecay) Rate(eta float64, p Progress) float64 {
+    return eta*math.Pow(s.factor, float64(p.Epoch/s.every))
+}
+
+type exponentialDecay struct {
+    gamma float64
+}
+
+// MakeExponentialDecay returns eta*gamma^t, t being the number of epochs
+// done including partial ones
+func MakeExponentialDecay(gamma float64) Schedule {
+    return exponentialDecay{gamma}
+}
+
+func (s exponentialDecay) Rate(eta float64, p Progress) float64 {
+    return eta*math.Pow(s.gamma, p.Epochs())
+}
+
+// ==================== Cosine annealing with warm restarts ===============
+
+type cosineRestarts struct {
+    period float64
+    mult float64
+    min_eta float64
+}
+
+// MakeCosineRestarts anneals the learning rate from eta down to min_eta along
+// a half cosine over `period` epochs, then restarts at eta. Each cycle is
+// mult times as long as the one before (SGDR).
+func MakeCosineRestarts(period, mult, min_eta float64) Schedule {
+    if period <= 0 {
+        period = 1
+    }
+    if mult < 1 {
+        mult = 1
+    }
+    return cosineRestarts{period, mult, min_eta}
+}
+
+func (s cosineRestarts) Rate(eta float64, p Progress) float64 {
+    t := p.Epochs()
+    cycle := s.period
+    for t >= cycle {
+        t -= cycle
+        cycle *= s.mult
+    }
+    return s.min_eta + 0.5*(eta-s.min_eta)*(1+math.Cos(math.Pi*t/cycle))
+}
+
+// ==================== Linear warmup ===============
+
+type linearWarmup struct {
+    epochs float64
+    after Schedule
+}
+
+// MakeLinearWarmup ramps the learning rate up linearly from 0 over the given
+// number of epochs, then hands over to `after` (nil for a fixed eta)
+func MakeLinearWarmup(epochs float64, after Schedule) Schedule {
+    return &linearWarmup{epochs, after}
+}
+
+func (s *linearWarmup) Rate(eta float64, p Progress) float64 {
+    target := eta
+    if s.after != nil {
+        target = s.after.Rate(eta, p)
+    }
+    if s.epochs <= 0 || p.BatchesPerEpoch == 0 {
+        return target
+    }
+    // Count the current batch so the very first step isn't taken with 0
+    done := (float64(p.Epoch*p.BatchesPerEpoch + p.Batch) + 1)/float64(p.BatchesPerEpoch)
+    if done >= s.epochs {
+        return target
+    }
+    return target*done/s.epochs
+}
+
+func (s *linearWarmup) Observe(accuracy float64) {
+    if obs, ok := s.after.(MetricObserver); ok {
+        obs.Observe(accuracy)
+    }
+}
+
+// ==================== Reduce on plateau ===============
+
+type reduceOnPlateau struct {
+    factor float64
+    patience int
+    min_delta float64
+    scale float64
+    best float64
+    bad_epochs int
+}
+
+// MakeReduceOnPlateau multiplies the learning rate by factor whenever the
+// validation accuracy hasn't improved by at least min_delta for more than
+// patience epochs
+func MakeReduceOnPlateau(factor float64, patience int, min_delta float64) Schedule {
+    return &reduceOnPlateau{
+        factor: factor,
+        patience: patience,
+        min_delta: min_delta,
+        scale: 1,
+        best: math.Inf(-1),
+    }
+}
+
+func (s *reduceOnPlateau) Rate(eta float64, p Progress) float64 {
+    return eta*s.scale
+}
+
+func (s *reduceOnPlateau) Observe(accuracy float64) {
+    if accuracy > s.best + s.min_delta {
+        s.best = accuracy
+        s.bad_epochs = 0
+        return
+    }
+    s.bad_epochs++
+    if s.bad_epochs > s.patience {
+        s.scale *= s.factor
+        s.bad_epochs = 0
+    }
+}
+
+// ParseSchedule builds a schedule from a name and comma separated parameters:
+//
+//     step:factor,every
+//     exp:gamma
+//     cosine:period,mult,min_eta
+//     plateau:factor,patience,min_delta
+//     warmup:epochs[+schedule]     e.g. "warmup:2+cosine:10,2,0"
+func ParseSchedule(spec string) (Schedule, error) {
+    spec = strings.ToLower(strings.TrimSpace(spec))
+    if spec == "" || spec == "constant" || spec == "none" {
+        return nil, nil
+    }
+    if rest, ok := strings.CutPrefix(spec, "warmup:"); ok {
+        epochs_str, after_spec, _ := strings.Cut(rest, "+")
+        epochs, err := strconv.ParseFloat(epochs_str, 64)
+        if err != nil {
+            return nil, fmt.Errorf("network: bad warmup in schedule %q: %w", spec, err)
+        }
+        after, err := ParseSchedule(after_spec)
+        if err != nil {
+            return nil, err
+        }
+        return MakeLinearWarmup(epochs, after), nil
+    }
+
+    name, param_str, _ := strings.Cut(spec, ":")
+    var params []float64
+    if param_str != "" {
+        for _, f := range strings.Split(param_str, ",") {
+            v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+            if err != nil {
+                return nil, fmt.Errorf("network: bad parameter in schedule %q: %w", spec, err)
+            }
+            params = append(params, v)
+        }
+    }
+    // param returns the ith parameter, or def when it wasn't given
+    param := func(i int, def float64) float64 {
+        if i < len(params) {
+            return params[i]
+        }
+        return def
+    }
+    switch name {
+    case "step":
+        return MakeStepDecay(param(0, 0.5), int(param(1, 10))), nil
+    case "exp", "exponential":
+        return MakeExponentialDecay(param(0, 0.95)), nil
+    case "cosine":
+        return MakeCosineRestarts(param(0, 10), param(1, 1), param(2, 0)), nil
+    case "plateau":
+        return MakeReduceOnPlateau(param(0, 0.5), int(param(1, 3)), param(2, 0)), nil
+    }
+    return nil, fmt.Errorf("network: unknown schedule %q", spec)
+}
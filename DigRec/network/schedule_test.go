@@ -0,0 +1,100 @@
+package network
+
+import (
+    "math"
+    "testing"
+)
+
+type rate_case struct {
+    p Progress
+    want float64
+}
+
+func check_rates(t *testing.T, name string, s Schedule, cases []rate_case) {
+    t.Helper()
+    for _, c := range cases {
+        if got := s.Rate(1, c.p); math.Abs(got - c.want) > 1e-12 {
+            t.Errorf("%s: rate at epoch %d batch %d is %g, want %g", name, c.p.Epoch, c.p.Batch, got, c.want)
+        }
+    }
+}
+
+// at returns the progress at batch b of epoch e, with 4 batches per epoch
+func at(e, b int) Progress {
+    return Progress{Epoch: e, Batch: b, BatchesPerEpoch: 4}
+}
+
+// The rate drops right at the epochs that are multiples of every, and
+// stays put within an epoch
+func TestStepDecay(t *testing.T) {
+    check_rates(t, "step", MakeStepDecay(0.5, 3), []rate_case{
+        {at(0, 0), 1}, {at(2, 3), 1}, {at(3, 0), 0.5}, {at(5, 3), 0.5}, {at(6, 0), 0.25}, {at(9, 2), 0.125},
+    })
+    check_rates(t, "step every 0", MakeStepDecay(0.1, 0), []rate_case{{at(0, 0), 1}, {at(2, 0), 0.01}})
+}
+
+// Exponential decay counts partial epochs
+func TestExponentialDecay(t *testing.T) {
+    check_rates(t, "exp", MakeExponentialDecay(0.5), []rate_case{
+        {at(0, 0), 1}, {at(1, 0), 0.5}, {at(2, 2), math.Pow(0.5, 2.5)}, {Progress{Epoch: 3}, 0.125},
+    })
+}
+
+// With a period of 2 doubling every cycle the restarts are at epochs 2 and
+// 6, and the rate is halfway down in the middle of every cycle
+func TestCosineRestarts(t *testing.T) {
+    check_rates(t, "cosine", MakeCosineRestarts(2, 2, 0.2), []rate_case{
+        {at(0, 0), 1}, {at(1, 0), 0.6}, {at(1, 2), 0.2 + 0.4*(1+math.Cos(0.75*math.Pi))},
+        {at(2, 0), 1}, {at(4, 0), 0.6}, {at(6, 0), 1}, {at(10, 0), 0.6},
+    })
+    check_rates(t, "cosine without mult", MakeCosineRestarts(2, 1, 0), []rate_case{
+        {at(1, 0), 0.5}, {at(2, 0), 1}, {at(3, 0), 0.5},
+    })
+}
+
+// Warmup counts the batch being taken, reaches the target at the last batch
+// of the warmup and then follows the schedule after it
+func TestLinearWarmup(t *testing.T) {
+    check_rates(t, "warmup", MakeLinearWarmup(2, nil), []rate_case{
+        {at(0, 0), 0.125}, {at(0, 3), 0.5}, {at(1, 1), 0.75}, {at(1, 3), 1}, {at(5, 0), 1},
+    })
+    check_rates(t, "warmup then step", MakeLinearWarmup(2, MakeStepDecay(0.5, 1)), []rate_case{
+        {at(0, 1), 0.25}, {at(1, 1), 0.375}, {at(2, 0), 0.25},
+    })
+}
+
+// The rate is cut once the accuracy hasn't improved by min_delta for more
+// than patience epochs, and the count starts over after a cut
+func TestReduceOnPlateau(t *testing.T) {
+    s := MakeReduceOnPlateau(0.5, 1, 0.01)
+    obs := s.(MetricObserver)
+    for i, step := range []struct {
+        accuracy float64
+        want float64
+    }{
+        {0.5, 1}, {0.6, 1}, {0.605, 1}, {0.6, 0.5}, {0.61, 0.5}, {0.7, 0.5}, {0.7, 0.5}, {0.7, 0.25},
+    } {
+        obs.Observe(step.accuracy)
+        if got := s.Rate(1, at(i, 0)); got != step.want {
+            t.Errorf("after observing %g at epoch %d: rate %g, want %g", step.accuracy, i, got, step.want)
+        }
+    }
+}
+
+func TestParseSchedule(t *testing.T) {
+    for _, spec := range []string{"", " none ", "constant"} {
+        if s, err := ParseSchedule(spec); s != nil || err != nil {
+            t.Errorf("ParseSchedule(%q) = %v, %v, want no schedule", spec, s, err)
+        }
+    }
+    s, err := ParseSchedule("Warmup:1+step:0.1,2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    check_rates(t, "parsed", s, []rate_case{{at(0, 1), 0.5}, {at(1, 0), 1}, {at(2, 0), 0.1}})
+    for _, spec := range []string{"step:x", "warmup:x", "warmup:1+bogus", "bogus"} {
+        if _, err := ParseSchedule(spec); err == nil {
+            t.Errorf("ParseSchedule(%q) should fail", spec)
+        }
+    }
+}
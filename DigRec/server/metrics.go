@@ -0,0 +1,114 @@
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Upper bounds of the request latency histogram, in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// metrics counts requests and predictions, written out in the Prometheus
+// text format:
+//
+//  digrec_requests_total{path, code}       requests answered
+//  digrec_request_duration_seconds{path}   histogram of request latency
+//  digrec_predictions_total{digit}         predictions of every class
+type metrics struct {
+    mu sync.Mutex
+    requests map[request_key]int
+    latency map[string]*histogram
+    predictions []int
+}
+
+type request_key struct {
+    path string
+    code int
+}
+
+type histogram struct {
+    counts []int // per bucket, not cumulative; the last one is +Inf
+    sum float64
+    count int
+}
+
+func make_metrics(classes int) *metrics {
+    return &metrics{
+        requests: make(map[request_key]int),
+        latency: make(map[string]*histogram),
+        predictions: make([]int, classes),
+    }
+}
+
+func (m *metrics) observe(path string, code int, d time.Duration) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.requests[request_key{path, code}]++
+    h := m.latency[path]
+    if h == nil {
+        h = &histogram{counts: make([]int, len(latencyBuckets)+1)}
+        m.latency[path] = h
+    }
+    secs := d.Seconds()
+    h.counts[sort.SearchFloat64s(latencyBuckets, secs)]++
+    h.sum += secs
+    h.count++
+}
+
+func (m *metrics) predicted(digit int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.predictions[digit]++
+}
+
+func (m *metrics) serve(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    fmt.Fprintln(w, "# HELP digrec_requests_total Requests answered, by path and status code.")
+    fmt.Fprintln(w, "# TYPE digrec_requests_total counter")
+    keys := make([]request_key, 0, len(m.requests))
+    for k := range m.requests {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].path != keys[j].path {
+            return keys[i].path < keys[j].path
+        }
+        return keys[i].code < keys[j].code
+    })
+    for _, k := range keys {
+        fmt.Fprintf(w, "digrec_requests_total{path=%q,code=\"%d\"} %d\n", k.path, k.code, m.requests[k])
+    }
+
+    fmt.Fprintln(w, "# HELP digrec_request_duration_seconds Request latency, by path.")
+    fmt.Fprintln(w, "# TYPE digrec_request_duration_seconds histogram")
+    paths := make([]string, 0, len(m.latency))
+    for p := range m.latency {
+        paths = append(paths, p)
+    }
+    sort.Strings(paths)
+    for _, p := range paths {
+        h := m.latency[p]
+        cumulative := 0
+        for i, le := range latencyBuckets {
+            cumulative += h.counts[i]
+            fmt.Fprintf(w, "digrec_request_duration_seconds_bucket{path=%q,le=%q} %d\n",
+                        p, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+        }
+        fmt.Fprintf(w, "digrec_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} %d\n", p, h.count)
+        fmt.Fprintf(w, "digrec_request_duration_seconds_sum{path=%q} %g\n", p, h.sum)
+        fmt.Fprintf(w, "digrec_request_duration_seconds_count{path=%q} %d\n", p, h.count)
+    }
+
+    fmt.Fprintln(w, "# HELP digrec_predictions_total Predictions made, by predicted digit.")
+    fmt.Fprintln(w, "# TYPE digrec_predictions_total counter")
+    for digit, n := range m.predictions {
+        fmt.Fprintf(w, "digrec_predictions_total{digit=\"%d\"} %d\n", digit, n)
+    }
+}
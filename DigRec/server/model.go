@@ -0,0 +1,74 @@
+package server
+
+import (
+    "NeuralNetworks/DigRec/network"
+)
+
+// ModelInfo is what GET /model returns
+type ModelInfo struct {
+    InputSize int `json:"input_size"`
+    OutputSize int `json:"output_size"`
+    // ImageWidth and ImageHeight are the images the network takes, 0 when
+    // its input isn't one gray image
+    ImageWidth int `json:"image_width,omitempty"`
+    ImageHeight int `json:"image_height,omitempty"`
+    Cost string `json:"cost"`
+    Params int `json:"params"`
+    Layers []LayerInfo `json:"layers"`
+}
+
+// LayerInfo describes one layer of the network
+type LayerInfo struct {
+    Kind string `json:"kind"`
+    InputSize int `json:"input_size"`
+    OutputSize int `json:"output_size"`
+    Activation string `json:"activation,omitempty"`
+    Params int `json:"params"`
+}
+
+func describe_model(m Model) ModelInfo {
+    info := ModelInfo{
+        InputSize: m.InputSize(),
+        OutputSize: m.Model().OutputSize(),
+        Cost: m.Cost().Name(),
+    }
+    if w, h, err := m.ImageSize(); err == nil {
+        info.ImageWidth, info.ImageHeight = w, h
+    }
+    for _, l := range m.Model().Layers() {
+        li := LayerInfo{Kind: layer_kind(l), InputSize: l.InputSize(), OutputSize: l.OutputSize()}
+        if al, ok := l.(*network.ActivationLayer); ok {
+            li.Activation = al.Activation().Name()
+        }
+        for _, p := range l.Params() {
+            li.Params += p.Value.Rows()*p.Value.Cols()
+        }
+        info.Params += li.Params
+        info.Layers = append(info.Layers, li)
+    }
+    return info
+}
+
+// layer_kind names a layer the way saved networks do
+func layer_kind(l network.Layer) string {
+    switch l := l.(type) {
+    case *network.Dense:
+        return "dense"
+    case *network.ActivationLayer:
+        return "activation"
+    case *network.Dropout:
+        return "dropout"
+    case *network.BatchNorm:
+        return "batchnorm"
+    case *network.Conv2D:
+        return "conv2d"
+    case *network.Pool:
+        if l.Max() {
+            return "maxpool"
+        }
+        return "avgpool"
+    case *network.Flatten:
+        return "flatten"
+    }
+    return "other"
+}
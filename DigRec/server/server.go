@@ -0,0 +1,436 @@
+// Package server serves a trained network over HTTP.
+//
+//  POST /predict        one image: a PNG, JPEG or GIF upload (as the body or
+//                       the "image" field of a multipart form), or JSON
+//                       {"pixels": [...], "top_k": k}
+//  POST /predict/batch  several images: JSON {"images": [[...], ...]} or a
+//                       multipart form with several "image" fields
+//  GET  /model          what the network looks like
+//  GET  /healthz        "ok" while the server is up
+//  GET  /metrics        Prometheus text format metrics
+//
+// Uploaded images go through mnist.Preprocess so they look like the MNIST
+// digits the network was trained on; JSON pixels are fed as they are, gray
+// levels in [0, 1] row by row with the digit bright on black.
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "io"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+    "NeuralNetworks/DigRec/network"
+)
+
+// Model is the part of a network the server uses, e.g. what network.LoadFile
+// returns. Its prediction methods are called from several goroutines at
+// once, so they must only read the network.
+type Model interface {
+    PredictMat(x *mottuMat.MottuMat, k int) ([]*network.Prediction, error)
+    ImageSize() (int, int, error)
+    Model() *network.Sequential
+    Cost() network.Cost
+    InputSize() int
+}
+
+// Defaults for the zero fields of Config
+const (
+    DefaultMaxBodyBytes = 4 << 20
+    DefaultMaxBatch = 256
+    DefaultMaxImagePixels = mnist.MaxPixels
+    DefaultMaxUploadPixels = 4*mnist.MaxPixels
+    DefaultTopK = 3
+)
+
+// Config holds the limits of a Server. Zero fields take the defaults.
+type Config struct {
+    MaxBodyBytes int64 // largest request body
+    MaxBatch int // most images in a /predict/batch request
+    MaxImagePixels int // largest uploaded image, in pixels, at most mnist.MaxPixels
+    MaxUploadPixels int // most pixels of all the images of a request together
+    TopK int // classes returned when the request doesn't say
+}
+
+func (c Config) with_defaults() Config {
+    if c.MaxBodyBytes <= 0 {
+        c.MaxBodyBytes = DefaultMaxBodyBytes
+    }
+    if c.MaxBatch <= 0 {
+        c.MaxBatch = DefaultMaxBatch
+    }
+    if c.MaxImagePixels <= 0 || c.MaxImagePixels > mnist.MaxPixels {
+        c.MaxImagePixels = DefaultMaxImagePixels
+    }
+    if c.MaxUploadPixels <= 0 {
+        c.MaxUploadPixels = DefaultMaxUploadPixels
+    }
+    if c.TopK <= 0 {
+        c.TopK = DefaultTopK
+    }
+    return c
+}
+
+// Server is an http.Handler serving predictions of a model
+type Server struct {
+    model Model
+    config Config
+    info ModelInfo
+    metrics *metrics
+    mux *http.ServeMux
+}
+
+// MakeServer returns a server for m. The model must not change while it is
+// being served.
+func MakeServer(m Model, config Config) *Server {
+    s := &Server{
+        model: m,
+        config: config.with_defaults(),
+        info: describe_model(m),
+        metrics: make_metrics(m.Model().OutputSize()),
+        mux: http.NewServeMux(),
+    }
+    s.handle("/predict", http.MethodPost, s.predict)
+    s.handle("/predict/batch", http.MethodPost, s.predict_batch)
+    s.handle("/model", http.MethodGet, s.model_info)
+    s.handle("/healthz", http.MethodGet, s.healthz)
+    s.handle("/metrics", http.MethodGet, s.metrics.serve)
+    return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.mux.ServeHTTP(w, r)
+}
+
+// handle registers h for path, answering other methods with 405 and timing
+// every request
+func (s *Server) handle(path, method string, h http.HandlerFunc) {
+    s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        sw := &status_writer{ResponseWriter: w, status: http.StatusOK}
+        if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+            sw.Header().Set("Allow", method)
+            write_error(sw, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+        } else {
+            r.Body = http.MaxBytesReader(sw, r.Body, s.config.MaxBodyBytes)
+            h(sw, r)
+        }
+        s.metrics.observe(path, sw.status, time.Since(start))
+    })
+}
+
+// status_writer remembers the status code of a response
+type status_writer struct {
+    http.ResponseWriter
+    status int
+}
+
+func (w *status_writer) WriteHeader(status int) {
+    w.status = status
+    w.ResponseWriter.WriteHeader(status)
+}
+
+// ==================== Handlers ===============
+
+// predictionJSON is a network.Prediction on the wire
+type predictionJSON struct {
+    Digit int `json:"digit"`
+    Probabilities []float64 `json:"probabilities"`
+    Top []classJSON `json:"top"`
+}
+
+type classJSON struct {
+    Class int `json:"class"`
+    Probability float64 `json:"probability"`
+}
+
+type predictRequest struct {
+    Pixels []float64 `json:"pixels"`
+    TopK int `json:"top_k"`
+}
+
+type batchRequest struct {
+    Images [][]float64 `json:"images"`
+    TopK int `json:"top_k"`
+}
+
+type batchResponse struct {
+    Predictions []predictionJSON `json:"predictions"`
+}
+
+func (s *Server) predict(w http.ResponseWriter, r *http.Request) {
+    var vecs []*mottuMat.MottuMat
+    k := 0
+    if is_json(r) {
+        var req predictRequest
+        if !decode_json(w, r, &req) {
+            return
+        }
+        vec, err := s.pixel_vec(req.Pixels)
+        if err != nil {
+            write_error(w, http.StatusBadRequest, "%v", err)
+            return
+        }
+        vecs, k = []*mottuMat.MottuMat{vec}, req.TopK
+    } else {
+        var ok bool
+        if vecs, ok = s.read_images(w, r, false); !ok {
+            return
+        }
+    }
+    predictions, ok := s.run(w, r, vecs, k)
+    if ok {
+        write_json(w, http.StatusOK, predictions[0])
+    }
+}
+
+func (s *Server) predict_batch(w http.ResponseWriter, r *http.Request) {
+    var vecs []*mottuMat.MottuMat
+    k := 0
+    if is_json(r) {
+        var req batchRequest
+        if !decode_json(w, r, &req) {
+            return
+        }
+        if len(req.Images) > s.config.MaxBatch {
+            write_error(w, http.StatusRequestEntityTooLarge, "%d images, at most %d allowed", len(req.Images), s.config.MaxBatch)
+            return
+        }
+        for i, pixels := range req.Images {
+            vec, err := s.pixel_vec(pixels)
+            if err != nil {
+                write_error(w, http.StatusBadRequest, "image %d: %v", i, err)
+                return
+            }
+            vecs = append(vecs, vec)
+        }
+        k = req.TopK
+    } else {
+        var ok bool
+        if vecs, ok = s.read_images(w, r, true); !ok {
+            return
+        }
+    }
+    if len(vecs) == 0 {
+        write_error(w, http.StatusBadRequest, "no images given")
+        return
+    }
+    predictions, ok := s.run(w, r, vecs, k)
+    if ok {
+        write_json(w, http.StatusOK, batchResponse{predictions})
+    }
+}
+
+// run classifies vecs as one batch and counts the predictions. k comes from
+// the request body and is overridden by the top_k query parameter.
+func (s *Server) run(w http.ResponseWriter, r *http.Request, vecs []*mottuMat.MottuMat, k int) ([]predictionJSON, bool) {
+    if q := r.URL.Query().Get("top_k"); q != "" {
+        var err error
+        if k, err = strconv.Atoi(q); err != nil {
+            write_error(w, http.StatusBadRequest, "bad top_k %q", q)
+            return nil, false
+        }
+    }
+    if k == 0 {
+        k = min(s.config.TopK, s.info.OutputSize)
+    }
+    predictions, err := s.model.PredictMat(mottuMat.HStack(vecs), k)
+    if err != nil {
+        write_error(w, http.StatusBadRequest, "%v", err)
+        return nil, false
+    }
+    retval := make([]predictionJSON, len(predictions))
+    for i, p := range predictions {
+        s.metrics.predicted(p.Digit)
+        retval[i] = predictionJSON{Digit: p.Digit, Probabilities: p.Probabilities}
+        for _, c := range p.Top {
+            retval[i].Top = append(retval[i].Top, classJSON{c.Class, c.Probability})
+        }
+    }
+    return retval, true
+}
+
+func (s *Server) model_info(w http.ResponseWriter, r *http.Request) {
+    write_json(w, http.StatusOK, s.info)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    io.WriteString(w, "ok\n")
+}
+
+// ==================== Input ===============
+
+// pixel_vec checks a JSON image and makes it a col vec
+func (s *Server) pixel_vec(pixels []float64) (*mottuMat.MottuMat, error) {
+    if len(pixels) != s.info.InputSize {
+        return nil, fmt.Errorf("got %d pixels, the network takes %d", len(pixels), s.info.InputSize)
+    }
+    vec := mottuMat.MakeColVec(len(pixels))
+    for i, v := range pixels {
+        if v < 0 || v > 1 {
+            return nil, fmt.Errorf("pixel %d is %g, not in [0, 1]", i, v)
+        }
+        vec.SetElem(i, 0, v)
+    }
+    return vec, nil
+}
+
+// read_images reads uploaded images, either the body or the "image" fields
+// of a multipart form. Only a batch may have more than one.
+func (s *Server) read_images(w http.ResponseWriter, r *http.Request, batch bool) ([]*mottuMat.MottuMat, bool) {
+    if s.info.ImageWidth == 0 {
+        write_error(w, http.StatusBadRequest, "the network doesn't take images, send JSON pixels")
+        return nil, false
+    }
+    var bodies [][]byte
+    media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if media == "multipart/form-data" {
+        mr, err := r.MultipartReader()
+        if err != nil {
+            write_error(w, http.StatusBadRequest, "%v", err)
+            return nil, false
+        }
+        for {
+            part, err := mr.NextPart()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                read_error(w, err)
+                return nil, false
+            }
+            if part.FormName() != "image" {
+                continue
+            }
+            if len(bodies) == s.config.MaxBatch {
+                write_error(w, http.StatusRequestEntityTooLarge, "more than %d images", s.config.MaxBatch)
+                return nil, false
+            }
+            body, err := io.ReadAll(part)
+            if err != nil {
+                read_error(w, err)
+                return nil, false
+            }
+            bodies = append(bodies, body)
+        }
+    } else {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            read_error(w, err)
+            return nil, false
+        }
+        bodies = append(bodies, body)
+    }
+    if len(bodies) == 0 {
+        write_error(w, http.StatusBadRequest, "no \"image\" field in the form")
+        return nil, false
+    }
+    if !batch && len(bodies) > 1 {
+        write_error(w, http.StatusBadRequest, "%d images given, use /predict/batch", len(bodies))
+        return nil, false
+    }
+
+    fail := func(i, status int, err error) ([]*mottuMat.MottuMat, bool) {
+        if len(bodies) > 1 {
+            write_error(w, status, "image %d: %v", i, err)
+        } else {
+            write_error(w, status, "%v", err)
+        }
+        return nil, false
+    }
+
+    // Size up every image before decoding any
+    total := 0
+    for i, body := range bodies {
+        pixels, status, err := s.image_pixels(body)
+        if err != nil {
+            return fail(i, status, err)
+        }
+        if total += pixels; total > s.config.MaxUploadPixels {
+            write_error(w, http.StatusRequestEntityTooLarge, "images of more than %d pixels in all", s.config.MaxUploadPixels)
+            return nil, false
+        }
+    }
+
+    vecs := make([]*mottuMat.MottuMat, len(bodies))
+    for i, body := range bodies {
+        img, err := mnist.DecodeImage(bytes.NewReader(body))
+        if err != nil {
+            return fail(i, http.StatusBadRequest, err)
+        }
+        raw, err := mnist.Preprocess(img)
+        if err != nil {
+            write_error(w, http.StatusRequestEntityTooLarge, "%v", err)
+            return nil, false
+        }
+        vecs[i] = mnist.ImageVec(raw, s.info.ImageWidth, s.info.ImageHeight)
+    }
+    return vecs, true
+}
+
+// image_pixels returns the number of pixels of an uploaded image from its
+// header, refusing ones too large to decode before the pixels are
+// allocated. It returns the status to report errors with.
+func (s *Server) image_pixels(body []byte) (int, int, error) {
+    config, _, err := image.DecodeConfig(bytes.NewReader(body))
+    if err != nil {
+        return 0, http.StatusUnsupportedMediaType, fmt.Errorf("not a PNG, JPEG or GIF image: %w", err)
+    }
+    if config.Width*config.Height > s.config.MaxImagePixels {
+        return 0, http.StatusRequestEntityTooLarge,
+               fmt.Errorf("image is %dx%d, at most %d pixels allowed", config.Width, config.Height, s.config.MaxImagePixels)
+    }
+    return config.Width*config.Height, 0, nil
+}
+
+// ==================== Helpers ===============
+
+func is_json(r *http.Request) bool {
+    media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    return media == "application/json" || strings.HasSuffix(media, "+json")
+}
+
+// decode_json reads the body into v, answering with an error if it can't
+func decode_json(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(v); err != nil {
+        read_error(w, err)
+        return false
+    }
+    return true
+}
+
+// read_error reports a body that couldn't be read, telling bodies over the
+// size limit from malformed ones
+func read_error(w http.ResponseWriter, err error) {
+    var too_large *http.MaxBytesError
+    if errors.As(err, &too_large) {
+        write_error(w, http.StatusRequestEntityTooLarge, "body larger than %d bytes", too_large.Limit)
+        return
+    }
+    write_error(w, http.StatusBadRequest, "bad request body: %v", err)
+}
+
+type errorJSON struct {
+    Error string `json:"error"`
+}
+
+func write_error(w http.ResponseWriter, status int, format string, a ...interface{}) {
+    write_json(w, status, errorJSON{fmt.Sprintf(format, a...)})
+}
+
+func write_json(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,484 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "io"
+    "math"
+    "math/rand"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "NeuralNetworks/DigRec/mnist"
+    "NeuralNetworks/DigRec/mottuMat"
+    "NeuralNetworks/DigRec/network"
+)
+
+// test_model is a network taking MNIST images, with random weights
+func test_model() Model {
+    mn := network.MakeMottuNetWithActivations([]int{mnist.Width*mnist.Height, 16, 10},
+                                              []network.Activation{network.Sigmoid, network.Softmax})
+    mn.Initialize(network.ScaledNormal, rand.New(rand.NewSource(7)))
+    mn.SetCost(network.LogLikelihood)
+    return mn
+}
+
+func test_server(t *testing.T, m Model, config Config) *httptest.Server {
+    ts := httptest.NewServer(MakeServer(m, config))
+    t.Cleanup(ts.Close)
+    return ts
+}
+
+// request sends a request and returns the status and body of the response
+func request(t *testing.T, method, url, content_type string, body []byte) (int, []byte) {
+    t.Helper()
+    req, err := http.NewRequest(method, url, bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if content_type != "" {
+        req.Header.Set("Content-Type", content_type)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return resp.StatusCode, data
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+    t.Helper()
+    if err := json.Unmarshal(data, v); err != nil {
+        t.Fatalf("decoding %q: %v", data, err)
+    }
+}
+
+// test_pixels returns the gray levels of a made up image, seeded by seed
+func test_pixels(seed int64) []float64 {
+    r := rand.New(rand.NewSource(seed))
+    pixels := make([]float64, mnist.Width*mnist.Height)
+    for i := range pixels {
+        pixels[i] = r.Float64()
+    }
+    return pixels
+}
+
+// test_png returns a PNG of a vertical bar, dark on white like a scan,
+// shifted right by shift pixels
+func test_png(t *testing.T, shift int) (image.Image, []byte) {
+    img := image.NewGray(image.Rect(0, 0, 40, 40))
+    for y := 0; y < 40; y++ {
+        for x := 0; x < 40; x++ {
+            img.SetGray(x, y, color.Gray{255})
+            if x >= 15+shift && x < 20+shift && y >= 5 && y < 35 {
+                img.SetGray(x, y, color.Gray{0})
+            }
+        }
+    }
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        t.Fatal(err)
+    }
+    return img, buf.Bytes()
+}
+
+// multipart_images returns a form with an "image" field for every body
+func multipart_images(t *testing.T, bodies ...[]byte) (string, []byte) {
+    var buf bytes.Buffer
+    mw := multipart.NewWriter(&buf)
+    for i, body := range bodies {
+        fw, err := mw.CreateFormFile("image", fmt.Sprintf("%d.png", i))
+        if err != nil {
+            t.Fatal(err)
+        }
+        fw.Write(body)
+    }
+    if err := mw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return mw.FormDataContentType(), buf.Bytes()
+}
+
+// want_prediction checks a prediction against what the model says for x
+func want_prediction(t *testing.T, m Model, x *mottuMat.MottuMat, got predictionJSON, k int) {
+    t.Helper()
+    want, err := m.PredictMat(x, k)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Digit != want[0].Digit || len(got.Top) != k || len(got.Probabilities) != 10 {
+        t.Fatalf("got digit %d with top %d of %d classes, want %d with top %d of 10",
+                 got.Digit, len(got.Top), len(got.Probabilities), want[0].Digit, k)
+    }
+    for i, c := range want[0].Top {
+        // Batches multiply in another order, which can move the last bit
+        if got.Top[i].Class != c.Class || math.Abs(got.Top[i].Probability - c.Probability) > 1e-12 {
+            t.Fatalf("top %d is %v, want %v", i, got.Top[i], c)
+        }
+    }
+}
+
+func pixel_vec(pixels []float64) *mottuMat.MottuMat {
+    x := mottuMat.MakeColVec(len(pixels))
+    for i, v := range pixels {
+        x.SetElem(i, 0, v)
+    }
+    return x
+}
+
+func image_vec(img image.Image) *mottuMat.MottuMat {
+    raw, _ := mnist.Preprocess(img)
+    return mnist.ImageVec(raw, mnist.Width, mnist.Height)
+}
+
+// ==================== /predict ===============
+
+func TestPredictJSON(t *testing.T) {
+    m := test_model()
+    ts := test_server(t, m, Config{})
+    pixels := test_pixels(1)
+    body, _ := json.Marshal(predictRequest{Pixels: pixels})
+
+    status, data := request(t, http.MethodPost, ts.URL+"/predict", "application/json", body)
+    if status != http.StatusOK {
+        t.Fatalf("status %d: %s", status, data)
+    }
+    var got predictionJSON
+    decode(t, data, &got)
+    want_prediction(t, m, pixel_vec(pixels), got, DefaultTopK)
+
+    // top_k in the query wins over the body
+    body, _ = json.Marshal(predictRequest{Pixels: pixels, TopK: 2})
+    status, data = request(t, http.MethodPost, ts.URL+"/predict?top_k=5", "application/json", body)
+    if status != http.StatusOK {
+        t.Fatalf("status %d: %s", status, data)
+    }
+    decode(t, data, &got)
+    want_prediction(t, m, pixel_vec(pixels), got, 5)
+
+    bad := []struct {
+        name string
+        body string
+        query string
+    }{
+        {"too few pixels", `{"pixels": [0.5]}`, ""},
+        {"pixel out of range", `{"pixels": [` + strings.Repeat("0,", 783) + `2]}`, ""},
+        {"unknown field", `{"pixel": []}`, ""},
+        {"malformed", `{"pixels": `, ""},
+        {"bad top_k", string(body), "?top_k=11"},
+    }
+    for _, test := range bad {
+        status, data := request(t, http.MethodPost, ts.URL+"/predict"+test.query, "application/json", []byte(test.body))
+        if status != http.StatusBadRequest {
+            t.Errorf("%s: status %d (%s), want 400", test.name, status, data)
+        }
+    }
+}
+
+func TestPredictPNG(t *testing.T) {
+    m := test_model()
+    ts := test_server(t, m, Config{})
+    img, body := test_png(t, 0)
+
+    status, data := request(t, http.MethodPost, ts.URL+"/predict", "image/png", body)
+    if status != http.StatusOK {
+        t.Fatalf("status %d: %s", status, data)
+    }
+    var got predictionJSON
+    decode(t, data, &got)
+    want_prediction(t, m, image_vec(img), got, DefaultTopK)
+
+    content_type, form := multipart_images(t, body)
+    status, data = request(t, http.MethodPost, ts.URL+"/predict", content_type, form)
+    if status != http.StatusOK {
+        t.Fatalf("multipart: status %d: %s", status, data)
+    }
+    decode(t, data, &got)
+    want_prediction(t, m, image_vec(img), got, DefaultTopK)
+
+    status, data = request(t, http.MethodPost, ts.URL+"/predict", "image/png", []byte("not a png"))
+    if status != http.StatusUnsupportedMediaType {
+        t.Errorf("not an image: status %d (%s), want 415", status, data)
+    }
+    content_type, form = multipart_images(t, body, body)
+    status, data = request(t, http.MethodPost, ts.URL+"/predict", content_type, form)
+    if status != http.StatusBadRequest {
+        t.Errorf("two images: status %d (%s), want 400", status, data)
+    }
+}
+
+// ==================== /predict/batch ===============
+
+func TestPredictBatch(t *testing.T) {
+    m := test_model()
+    ts := test_server(t, m, Config{MaxBatch: 2})
+
+    images := [][]float64{test_pixels(1), test_pixels(2)}
+    body, _ := json.Marshal(batchRequest{Images: images, TopK: 1})
+    status, data := request(t, http.MethodPost, ts.URL+"/predict/batch", "application/json", body)
+    if status != http.StatusOK {
+        t.Fatalf("status %d: %s", status, data)
+    }
+    var got batchResponse
+    decode(t, data, &got)
+    if len(got.Predictions) != len(images) {
+        t.Fatalf("%d predictions for %d images", len(got.Predictions), len(images))
+    }
+    for i, pixels := range images {
+        want_prediction(t, m, pixel_vec(pixels), got.Predictions[i], 1)
+    }
+
+    img0, png0 := test_png(t, 0)
+    img1, png1 := test_png(t, 6)
+    content_type, form := multipart_images(t, png0, png1)
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", content_type, form)
+    if status != http.StatusOK {
+        t.Fatalf("multipart: status %d: %s", status, data)
+    }
+    decode(t, data, &got)
+    if len(got.Predictions) != 2 {
+        t.Fatalf("%d predictions for 2 images", len(got.Predictions))
+    }
+    want_prediction(t, m, image_vec(img0), got.Predictions[0], DefaultTopK)
+    want_prediction(t, m, image_vec(img1), got.Predictions[1], DefaultTopK)
+
+    // Over MaxBatch
+    body, _ = json.Marshal(batchRequest{Images: append(images, test_pixels(3))})
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", "application/json", body)
+    if status != http.StatusRequestEntityTooLarge {
+        t.Errorf("3 JSON images: status %d (%s), want 413", status, data)
+    }
+    content_type, form = multipart_images(t, png0, png1, png0)
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", content_type, form)
+    if status != http.StatusRequestEntityTooLarge {
+        t.Errorf("3 uploaded images: status %d (%s), want 413", status, data)
+    }
+
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", "application/json", []byte(`{"images": []}`))
+    if status != http.StatusBadRequest {
+        t.Errorf("no images: status %d (%s), want 400", status, data)
+    }
+}
+
+// ==================== Limits ===============
+
+func TestOversizedBody(t *testing.T) {
+    ts := test_server(t, test_model(), Config{MaxBodyBytes: 1000, MaxImagePixels: 50*50})
+
+    body, _ := json.Marshal(predictRequest{Pixels: test_pixels(1)})
+    for _, path := range []string{"/predict", "/predict/batch"} {
+        status, data := request(t, http.MethodPost, ts.URL+path, "application/json", body)
+        if status != http.StatusRequestEntityTooLarge {
+            t.Errorf("%s: status %d (%s), want 413", path, status, data)
+        }
+    }
+    status, data := request(t, http.MethodPost, ts.URL+"/predict", "image/png", make([]byte, 2000))
+    if status != http.StatusRequestEntityTooLarge {
+        t.Errorf("upload: status %d (%s), want 413", status, data)
+    }
+
+    // Small to send, too many pixels to decode
+    var buf bytes.Buffer
+    png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 100)))
+    status, data = request(t, http.MethodPost, ts.URL+"/predict", "image/png", buf.Bytes())
+    if status != http.StatusRequestEntityTooLarge {
+        t.Errorf("large image: status %d (%s), want 413", status, data)
+    }
+}
+
+// The images of a request are sized up together before any is decoded
+func TestUploadPixels(t *testing.T) {
+    ts := test_server(t, test_model(), Config{MaxUploadPixels: 2*40*40})
+    _, png0 := test_png(t, 0)
+    _, png1 := test_png(t, 5)
+
+    content_type, form := multipart_images(t, png0, png1)
+    status, data := request(t, http.MethodPost, ts.URL+"/predict/batch", content_type, form)
+    if status != http.StatusOK {
+        t.Errorf("2 images at the limit: status %d (%s), want 200", status, data)
+    }
+    content_type, form = multipart_images(t, png0, png1, png0)
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", content_type, form)
+    if status != http.StatusRequestEntityTooLarge || !strings.Contains(string(data), "3200 pixels in all") {
+        t.Errorf("3 images over the limit: status %d (%s), want 413", status, data)
+    }
+
+    // The limit holds before a bad image later in the batch is looked at
+    content_type, form = multipart_images(t, png0, png1, png0, []byte("not an image"))
+    status, data = request(t, http.MethodPost, ts.URL+"/predict/batch", content_type, form)
+    if status != http.StatusRequestEntityTooLarge {
+        t.Errorf("images over the limit then a bad one: status %d (%s), want 413", status, data)
+    }
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+    ts := test_server(t, test_model(), Config{})
+    tests := []struct {
+        method string
+        path string
+        allow string
+    }{
+        {http.MethodGet, "/predict", http.MethodPost},
+        {http.MethodPut, "/predict/batch", http.MethodPost},
+        {http.MethodPost, "/model", http.MethodGet},
+        {http.MethodDelete, "/healthz", http.MethodGet},
+        {http.MethodPost, "/metrics", http.MethodGet},
+    }
+    for _, test := range tests {
+        req, _ := http.NewRequest(test.method, ts.URL+test.path, nil)
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != test.allow {
+            t.Errorf("%s %s: status %d, Allow %q, want 405 and %q",
+                     test.method, test.path, resp.StatusCode, resp.Header.Get("Allow"), test.allow)
+        }
+    }
+}
+
+// ==================== Other endpoints ===============
+
+func TestModelHealthzMetrics(t *testing.T) {
+    m := test_model()
+    ts := test_server(t, m, Config{})
+
+    status, data := request(t, http.MethodGet, ts.URL+"/model", "", nil)
+    if status != http.StatusOK {
+        t.Fatalf("/model: status %d: %s", status, data)
+    }
+    var info ModelInfo
+    decode(t, data, &info)
+    if info.InputSize != 784 || info.OutputSize != 10 || info.ImageWidth != mnist.Width ||
+       info.ImageHeight != mnist.Height || info.Cost != "log_likelihood" || len(info.Layers) != 4 ||
+       info.Params != 784*16+16+16*10+10 {
+        t.Errorf("/model: got %+v", info)
+    }
+
+    status, data = request(t, http.MethodGet, ts.URL+"/healthz", "", nil)
+    if status != http.StatusOK || string(data) != "ok\n" {
+        t.Errorf("/healthz: status %d, body %q", status, data)
+    }
+
+    pixels := test_pixels(1)
+    body, _ := json.Marshal(predictRequest{Pixels: pixels})
+    request(t, http.MethodPost, ts.URL+"/predict", "application/json", body)
+    request(t, http.MethodGet, ts.URL+"/predict", "", nil)
+    predictions, _ := m.PredictMat(pixel_vec(pixels), 1)
+
+    status, data = request(t, http.MethodGet, ts.URL+"/metrics", "", nil)
+    if status != http.StatusOK {
+        t.Fatalf("/metrics: status %d: %s", status, data)
+    }
+    for _, line := range []string{
+        `digrec_requests_total{path="/predict",code="200"} 1`,
+        `digrec_requests_total{path="/predict",code="405"} 1`,
+        `digrec_requests_total{path="/model",code="200"} 1`,
+        `digrec_request_duration_seconds_count{path="/predict"} 2`,
+        fmt.Sprintf(`digrec_predictions_total{digit="%d"} 1`, predictions[0].Digit),
+    } {
+        if !strings.Contains(string(data), line+"\n") {
+            t.Errorf("/metrics is missing %q:\n%s", line, data)
+        }
+    }
+}
+
+// ==================== Concurrency ===============
+
+// Requests are served at once, which -race checks the model and the
+// metrics for
+func TestConcurrentRequests(t *testing.T) {
+    m := test_model()
+    ts := test_server(t, m, Config{})
+    img, png_body := test_png(t, 0)
+    want, _ := m.PredictMat(image_vec(img), DefaultTopK)
+
+    const clients, each = 8, 10
+    var wg sync.WaitGroup
+    errs := make(chan error, clients*each)
+    for c := 0; c < clients; c++ {
+        wg.Add(1)
+        go func(c int) {
+            defer wg.Done()
+            for i := 0; i < each; i++ {
+                var body []byte
+                content_type := "image/png"
+                path := "/predict"
+                switch i % 3 {
+                case 0:
+                    body = png_body
+                case 1:
+                    body, _ = json.Marshal(batchRequest{Images: [][]float64{test_pixels(int64(c)), test_pixels(int64(i))}})
+                    content_type, path = "application/json", "/predict/batch"
+                case 2:
+                    path, body = "/metrics", nil
+                }
+                method := http.MethodPost
+                if body == nil {
+                    method = http.MethodGet
+                }
+                req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+                req.Header.Set("Content-Type", content_type)
+                resp, err := http.DefaultClient.Do(req)
+                if err != nil {
+                    errs <- err
+                    continue
+                }
+                data, _ := io.ReadAll(resp.Body)
+                resp.Body.Close()
+                if resp.StatusCode != http.StatusOK {
+                    errs <- fmt.Errorf("%s: status %d: %s", path, resp.StatusCode, data)
+                    continue
+                }
+                if i % 3 == 0 {
+                    var got predictionJSON
+                    if err := json.Unmarshal(data, &got); err != nil || got.Digit != want[0].Digit {
+                        errs <- fmt.Errorf("predicted %d (%v), want %d", got.Digit, err, want[0].Digit)
+                    }
+                }
+            }
+        }(c)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+
+    _, data := request(t, http.MethodGet, ts.URL+"/metrics", "", nil)
+    line := fmt.Sprintf(`digrec_requests_total{path="/predict",code="200"} %d`, clients*4)
+    if !strings.Contains(string(data), line+"\n") {
+        t.Errorf("/metrics is missing %q:\n%s", line, data)
+    }
+}
+
+// Layers are named the way saved networks name them
+func TestLayerKinds(t *testing.T) {
+    m := network.MakeMottuNetFromLayers(
+        network.MakeMaxPool(network.Volume{Channels: 1, Height: 28, Width: 28}, 2, 2),
+        network.MakeAvgPool(network.Volume{Channels: 1, Height: 14, Width: 14}, 2, 2),
+        network.MakeFlatten(network.Volume{Channels: 1, Height: 7, Width: 7}),
+        network.MakeDense(49, 10),
+        network.MakeActivationLayer(10, network.Softmax),
+    )
+    var kinds []string
+    for _, l := range describe_model(m).Layers {
+        kinds = append(kinds, l.Kind)
+    }
+    if want := "[maxpool avgpool flatten dense activation]"; fmt.Sprint(kinds) != want {
+        t.Errorf("layer kinds %v, want %s", kinds, want)
+    }
+}